@@ -0,0 +1,204 @@
+package pdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DBAttributes holds the attribute bits from the database header.
+type DBAttributes uint16
+
+// Database attribute bits, as documented in the PalmOS file format
+// docs.
+const (
+	// The database is a resource database, such as an application.
+	AttrResDB DBAttributes = 0x0001
+	// The database is read-only.
+	AttrReadOnly DBAttributes = 0x0002
+	// The AppInfo block has been modified.
+	AttrAppInfoDirty DBAttributes = 0x0004
+	// The database should be backed up to the desktop on HotSync.
+	AttrBackup DBAttributes = 0x0008
+	// It's OK to install a newer version of the database over this
+	// one even if it's open.
+	AttrOKToInstallNewer DBAttributes = 0x0010
+	// The device should be reset after this database is installed.
+	AttrResetAfterInstall DBAttributes = 0x0020
+	// The database shouldn't be copied to other devices.
+	AttrCopyPrevention DBAttributes = 0x0040
+	// The database is a file stream rather than a record database.
+	AttrStream DBAttributes = 0x0080
+	// The database should be hidden from launchers.
+	AttrHidden DBAttributes = 0x0100
+	// The database is data that can be launched like an
+	// application.
+	AttrLaunchableData DBAttributes = 0x0200
+	// The database should be deleted when it's closed.
+	AttrRecyclable DBAttributes = 0x0400
+	// The database is bundled with its application.
+	AttrBundle DBAttributes = 0x0800
+	// The database was open when it was written out.
+	AttrOpen DBAttributes = 0x8000
+)
+
+var dbAttrNames = []struct {
+	attr DBAttributes
+	name string
+}{
+	{AttrResDB, "ResDB"},
+	{AttrReadOnly, "ReadOnly"},
+	{AttrAppInfoDirty, "AppInfoDirty"},
+	{AttrBackup, "Backup"},
+	{AttrOKToInstallNewer, "OKToInstallNewer"},
+	{AttrResetAfterInstall, "ResetAfterInstall"},
+	{AttrCopyPrevention, "CopyPrevention"},
+	{AttrStream, "Stream"},
+	{AttrHidden, "Hidden"},
+	{AttrLaunchableData, "LaunchableData"},
+	{AttrRecyclable, "Recyclable"},
+	{AttrBundle, "Bundle"},
+	{AttrOpen, "Open"},
+}
+
+// Has returns true if all the bits in f are set.
+func (a DBAttributes) Has(f DBAttributes) bool {
+	return a&f == f
+}
+
+// Set turns on the bits in f.
+func (a *DBAttributes) Set(f DBAttributes) {
+	*a |= f
+}
+
+// Clear turns off the bits in f.
+func (a *DBAttributes) Clear(f DBAttributes) {
+	*a &^= f
+}
+
+// String returns the names of the set bits, separated by |. Bits
+// without a name are shown in hex.
+func (a DBAttributes) String() string {
+	var names []string
+	left := a
+	for _, n := range dbAttrNames {
+		if a.Has(n.attr) {
+			names = append(names, n.name)
+			left &^= n.attr
+		}
+	}
+	if left != 0 {
+		names = append(names, fmt.Sprintf("%#04x", uint16(left)))
+	}
+	if len(names) == 0 {
+		return "0"
+	}
+	return strings.Join(names, "|")
+}
+
+// validate makes sure no contradictory bits are set. Only bits that
+// can't go together in any database are checked. Read doesn't check
+// them, so a file with a bad combination can be read but has to be
+// fixed before it can be written back out.
+func (a DBAttributes) validate() error {
+	if a.Has(AttrResDB) && a.Has(AttrStream) {
+		return fmt.Errorf("Attributes %v: a resource database can't be a file stream", a)
+	}
+	if a.Has(AttrResDB) && a.Has(AttrLaunchableData) {
+		return fmt.Errorf("Attributes %v: a resource database can't be launchable data", a)
+	}
+	return nil
+}
+
+// DBAttributes returns the database's attribute bits.
+func (p *Pdb) DBAttributes() DBAttributes {
+	return DBAttributes(p.Attributes)
+}
+
+// SetDBAttributes replaces the database's attribute bits with a.
+func (p *Pdb) SetDBAttributes(a DBAttributes) {
+	p.Attributes = uint16(a)
+}
+
+// RecordAttributes holds the attribute bits and category of a
+// record. The top four bits are flags and the bottom four are the
+// record's category.
+type RecordAttributes uint8
+
+// Record attribute bits.
+const (
+	// The record has been deleted.
+	RecAttrDelete RecordAttributes = 0x80
+	// The record has been modified.
+	RecAttrDirty RecordAttributes = 0x40
+	// The record is in use.
+	RecAttrBusy RecordAttributes = 0x20
+	// The record is private.
+	RecAttrSecret RecordAttributes = 0x10
+
+	// Mask for the category number in the low bits.
+	recAttrCategoryMask RecordAttributes = 0x0f
+)
+
+var recAttrNames = []struct {
+	attr RecordAttributes
+	name string
+}{
+	{RecAttrDelete, "Delete"},
+	{RecAttrDirty, "Dirty"},
+	{RecAttrBusy, "Busy"},
+	{RecAttrSecret, "Secret"},
+}
+
+// Has returns true if all the bits in f are set.
+func (a RecordAttributes) Has(f RecordAttributes) bool {
+	return a&f == f
+}
+
+// Set turns on the bits in f.
+func (a *RecordAttributes) Set(f RecordAttributes) {
+	*a |= f
+}
+
+// Clear turns off the bits in f.
+func (a *RecordAttributes) Clear(f RecordAttributes) {
+	*a &^= f
+}
+
+// Category returns the record's category number, from 0 to 15.
+func (a RecordAttributes) Category() int {
+	return int(a & recAttrCategoryMask)
+}
+
+// SetCategory sets the record's category number, which must be from
+// 0 to 15.
+func (a *RecordAttributes) SetCategory(c int) error {
+	if c < 0 || c > int(recAttrCategoryMask) {
+		return fmt.Errorf("Category %v out of range 0-%v", c, int(recAttrCategoryMask))
+	}
+	*a = *a&^recAttrCategoryMask | RecordAttributes(c)
+	return nil
+}
+
+// String returns the names of the set bits, separated by |, followed
+// by the category.
+func (a RecordAttributes) String() string {
+	var names []string
+	for _, n := range recAttrNames {
+		if a.Has(n.attr) {
+			names = append(names, n.name)
+		}
+	}
+	names = append(names, fmt.Sprintf("Category(%v)", a.Category()))
+	return strings.Join(names, "|")
+}
+
+// Attributes returns the record's attribute bits and category.
+func (r *Record) Attributes() RecordAttributes {
+	return RecordAttributes(uint8(r.Attribs))
+}
+
+// SetAttributes replaces the record's attribute bits and category
+// with a.
+func (r *Record) SetAttributes(a RecordAttributes) {
+	r.Attribs = int8(a)
+}
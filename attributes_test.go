@@ -0,0 +1,132 @@
+package pdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestDBAttributes(t *testing.T) {
+	var a DBAttributes
+	a.Set(AttrBackup | AttrHidden)
+	if !a.Has(AttrBackup) || !a.Has(AttrHidden) {
+		t.Errorf("Set(Backup|Hidden): got %v", a)
+	}
+	if a.Has(AttrBackup | AttrReadOnly) {
+		t.Errorf("Has(Backup|ReadOnly) on %v: got true, want false", a)
+	}
+	a.Clear(AttrHidden)
+	if a != AttrBackup {
+		t.Errorf("Clear(Hidden): got %v, want %v", a, AttrBackup)
+	}
+}
+
+func TestDBAttributesString(t *testing.T) {
+	tests := []struct {
+		in   DBAttributes
+		want string
+	}{
+		{0, "0"},
+		{AttrResDB, "ResDB"},
+		{AttrReadOnly | AttrBackup | AttrOpen, "ReadOnly|Backup|Open"},
+		{AttrStream | 0x1000, "Stream|0x1000"},
+	}
+
+	for _, test := range tests {
+		if got := test.in.String(); got != test.want {
+			t.Errorf("DBAttributes(%#04x).String(): got %q, want %q", uint16(test.in), got, test.want)
+		}
+	}
+}
+
+func TestRecordAttributes(t *testing.T) {
+	var a RecordAttributes
+	a.Set(RecAttrDirty | RecAttrSecret)
+	if err := a.SetCategory(5); err != nil {
+		t.Fatalf("SetCategory(5): %v", err)
+	}
+	if a != 0x55 {
+		t.Errorf("Attributes: got %#02x, want %#02x", uint8(a), 0x55)
+	}
+	if got := a.Category(); got != 5 {
+		t.Errorf("Category: got %v, want 5", got)
+	}
+	if err := a.SetCategory(16); err == nil {
+		t.Errorf("SetCategory(16): got nil error, want one")
+	}
+	a.Clear(RecAttrDirty)
+	if got, want := a.String(), "Secret|Category(5)"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+}
+
+func TestValidateAttributes(t *testing.T) {
+	tests := []struct {
+		attr DBAttributes
+		ok   bool
+	}{
+		{AttrBackup | AttrHidden, true},
+		{AttrResDB | AttrStream, false},
+		{AttrResDB | AttrLaunchableData, false},
+		// Databases in ROM can be both.
+		{AttrReadOnly | AttrAppInfoDirty, true},
+	}
+
+	now := time.Now()
+	for _, test := range tests {
+		p := &Pdb{
+			Attributes: uint16(test.attr),
+			Filetype:   "TEST",
+			Creator:    "TEST",
+			CreateTime: now,
+			ModTime:    now,
+		}
+		err := p.Validate()
+		if (err == nil) != test.ok {
+			t.Errorf("Validate(%v): got %v, want ok %v", test.attr, err, test.ok)
+		}
+	}
+}
+
+// TestReadOnlyDirtyRoundTrip checks a file with attributes Read
+// accepts can be written back out.
+func TestReadOnlyDirtyRoundTrip(t *testing.T) {
+	raw := threeRecords(t)
+	binary.BigEndian.PutUint16(raw[32:], uint16(AttrReadOnly|AttrAppInfoDirty))
+	p, err := ReadFH(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadFH error: %v", err)
+	}
+	var b bytes.Buffer
+	if err := p.WriteFH(&b); err != nil {
+		t.Fatalf("WriteFH error: %v", err)
+	}
+	got, err := ReadFH(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadFH of written file error: %v", err)
+	}
+	if got.DBAttributes() != AttrReadOnly|AttrAppInfoDirty {
+		t.Errorf("got attributes %v, want ReadOnly|AppInfoDirty", got.DBAttributes())
+	}
+}
+
+func TestAttributeAccessors(t *testing.T) {
+	p := &Pdb{Attributes: 0x0009}
+	if got, want := p.DBAttributes(), AttrResDB|AttrBackup; got != want {
+		t.Errorf("DBAttributes: got %v, want %v", got, want)
+	}
+	p.SetDBAttributes(AttrHidden)
+	if p.Attributes != 0x0100 {
+		t.Errorf("Attributes after SetDBAttributes: got %#04x, want %#04x", p.Attributes, 0x0100)
+	}
+
+	r := &Record{Attribs: -128}
+	if got, want := r.Attributes(), RecAttrDelete; got != want {
+		t.Errorf("Attributes: got %v, want %v", got, want)
+	}
+	r.SetAttributes(RecAttrDelete | RecAttrSecret | 3)
+	if r.Attribs != -128+0x13 {
+		t.Errorf("Attribs after SetAttributes: got %v, want %v", r.Attribs, -128+0x13)
+	}
+}
@@ -0,0 +1,129 @@
+package pdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Sizes of the parts of the standard category block.
+const (
+	numCategories       = 16
+	categoryNameSize    = 16
+	appInfoCategorySize = 2 + numCategories*categoryNameSize + numCategories + 2
+)
+
+// AppInfoCategories is the standard PalmOS category block. The
+// AppInfo of most built-in databases (Memo, Address, ToDo, Datebook
+// and friends) starts with one, followed by any app-specific data.
+// Records pick their category with the low four bits of their
+// attributes.
+type AppInfoCategories struct {
+	// Bit i is set if category i has been renamed by the user.
+	Renamed uint16
+	// Category names. Each can be up to 15 bytes long. Unused
+	// categories have empty names.
+	Names [numCategories]string
+	// Unique IDs of the categories.
+	IDs [numCategories]uint8
+	// The last unique ID handed out to a category.
+	LastUniqueID uint8
+}
+
+// UnmarshalBinary decodes a category block from the start of b, which
+// is usually a database's AppInfo. Anything after the category block
+// is ignored.
+func (c *AppInfoCategories) UnmarshalBinary(b []byte) error {
+	if len(b) < appInfoCategorySize {
+		return fmt.Errorf("Category block needs %v bytes, only got %v", appInfoCategorySize, len(b))
+	}
+	c.Renamed = binary.BigEndian.Uint16(b)
+	o := 2
+	for i := range c.Names {
+		n := string(b[o : o+categoryNameSize])
+		if e := strings.Index(n, "\x00"); e != -1 {
+			n = n[0:e]
+		}
+		c.Names[i] = n
+		o += categoryNameSize
+	}
+	copy(c.IDs[:], b[o:])
+	o += numCategories
+	c.LastUniqueID = b[o]
+	return nil
+}
+
+// MarshalBinary encodes the category block.
+func (c *AppInfoCategories) MarshalBinary() ([]byte, error) {
+	b := make([]byte, appInfoCategorySize)
+	binary.BigEndian.PutUint16(b, c.Renamed)
+	o := 2
+	for i, n := range c.Names {
+		// Names need room for a trailing NUL.
+		if len(n) >= categoryNameSize {
+			return nil, fmt.Errorf("Category %v name %q is longer than %v bytes", i, n, categoryNameSize-1)
+		}
+		copy(b[o:], n)
+		o += categoryNameSize
+	}
+	copy(b[o:], c.IDs[:])
+	o += numCategories
+	b[o] = c.LastUniqueID
+	// The last byte is padding.
+	return b, nil
+}
+
+// Index returns the number of the category with the passed-in name,
+// or -1 if there isn't one.
+func (c *AppInfoCategories) Index(name string) int {
+	for i, n := range c.Names {
+		if n == name && n != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// Name returns the name of the category r is in.
+func (c *AppInfoCategories) Name(r *Record) string {
+	return c.Names[r.Attributes().Category()]
+}
+
+// Group sorts records by the number of the category they're in; the
+// category's name is in Names. Categories are keyed by number rather
+// than name since unused categories all have the same empty name. The
+// records keep their relative order within each category.
+func (c *AppInfoCategories) Group(records []*Record) map[int][]*Record {
+	g := make(map[int][]*Record)
+	for _, r := range records {
+		i := r.Attributes().Category()
+		g[i] = append(g[i], r)
+	}
+	return g
+}
+
+// Categories decodes the category block at the start of the AppInfo.
+func (p *Pdb) Categories() (*AppInfoCategories, error) {
+	c := &AppInfoCategories{}
+	if err := c.UnmarshalBinary(p.AppInfo); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetCategories encodes c into the start of the AppInfo. If the
+// AppInfo is already big enough to hold a category block then the pad
+// byte at the end of the block and any app-specific data after it are
+// kept, otherwise the AppInfo is replaced.
+func (p *Pdb) SetCategories(c *AppInfoCategories) error {
+	b, err := c.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if len(p.AppInfo) >= appInfoCategorySize {
+		b[appInfoCategorySize-1] = p.AppInfo[appInfoCategorySize-1]
+		b = append(b, p.AppInfo[appInfoCategorySize:]...)
+	}
+	p.AppInfo = b
+	return nil
+}
@@ -0,0 +1,106 @@
+package pdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAppInfoCategories(t *testing.T) {
+	c := &AppInfoCategories{
+		Renamed:      0x0006,
+		LastUniqueID: 17,
+	}
+	c.Names[0] = "Unfiled"
+	c.Names[1] = "Business"
+	c.Names[2] = "Personal"
+	c.IDs = [16]uint8{0, 1, 2}
+
+	p := &Pdb{AppInfo: []byte("old")}
+	if err := p.SetCategories(c); err != nil {
+		t.Fatalf("SetCategories: %v", err)
+	}
+	if len(p.AppInfo) != appInfoCategorySize {
+		t.Errorf("AppInfo size: got %v, want %v", len(p.AppInfo), appInfoCategorySize)
+	}
+	if !bytes.Equal(p.AppInfo[0:2], []byte{0, 6}) {
+		t.Errorf("Renamed bits: got %v, want %v", p.AppInfo[0:2], []byte{0, 6})
+	}
+	if got := string(p.AppInfo[2+16 : 2+16+9]); got != "Business\x00" {
+		t.Errorf("Category 1 name on disk: got %q, want %q", got, "Business\x00")
+	}
+
+	// App-specific data after the block survives updates.
+	p.AppInfo = append(p.AppInfo, "app data"...)
+	c.Names[3] = "Travel"
+	if err := p.SetCategories(c); err != nil {
+		t.Fatalf("SetCategories: %v", err)
+	}
+	if got := string(p.AppInfo[appInfoCategorySize:]); got != "app data" {
+		t.Errorf("App-specific data: got %q, want %q", got, "app data")
+	}
+
+	got, err := p.Categories()
+	if err != nil {
+		t.Fatalf("Categories: %v", err)
+	}
+	if *got != *c {
+		t.Errorf("Categories: got %+v, want %+v", got, c)
+	}
+	if i := got.Index("Travel"); i != 3 {
+		t.Errorf("Index(Travel): got %v, want 3", i)
+	}
+	if i := got.Index("Nowhere"); i != -1 {
+		t.Errorf("Index(Nowhere): got %v, want -1", i)
+	}
+
+	c.Names[4] = "Much too long a name"
+	if err := p.SetCategories(c); err == nil {
+		t.Errorf("SetCategories with a long name: got nil error, want one")
+	}
+
+	// An unedited block with a nonzero pad byte round trips.
+	p.AppInfo[appInfoCategorySize-1] = 0xaa
+	want := append([]byte(nil), p.AppInfo...)
+	c, err = p.Categories()
+	if err != nil {
+		t.Fatalf("Categories: %v", err)
+	}
+	if err := p.SetCategories(c); err != nil {
+		t.Fatalf("SetCategories: %v", err)
+	}
+	if !bytes.Equal(p.AppInfo, want) {
+		t.Errorf("AppInfo changed by decoding and encoding: got %v, want %v", p.AppInfo, want)
+	}
+
+	p.AppInfo = p.AppInfo[:100]
+	if _, err := p.Categories(); err == nil {
+		t.Errorf("Categories from a short AppInfo: got nil error, want one")
+	}
+}
+
+func TestGroupCategories(t *testing.T) {
+	c := &AppInfoCategories{}
+	c.Names[0] = "Unfiled"
+	c.Names[1] = "Business"
+
+	records := []*Record{
+		{UniqueID: 1, Attribs: 0x01},
+		{UniqueID: 2, Attribs: int8(RecAttrDirty)},
+		{UniqueID: 3, Attribs: int8(RecAttrSecret | 0x01)},
+	}
+	// Two records in different unnamed categories.
+	records = append(records, &Record{UniqueID: 4, Attribs: 0x05}, &Record{UniqueID: 5, Attribs: 0x06})
+	g := c.Group(records)
+	if len(g[1]) != 2 || g[1][0].UniqueID != 1 || g[1][1].UniqueID != 3 {
+		t.Errorf("Business: got %v", g[1])
+	}
+	if len(g[0]) != 1 || g[0][0].UniqueID != 2 {
+		t.Errorf("Unfiled: got %v", g[0])
+	}
+	if len(g[5]) != 1 || len(g[6]) != 1 {
+		t.Errorf("Unnamed categories 5 and 6: got %v and %v", g[5], g[6])
+	}
+	if n := c.Name(records[2]); n != "Business" {
+		t.Errorf("Name(record 3): got %q, want %q", n, "Business")
+	}
+}
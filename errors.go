@@ -0,0 +1,72 @@
+package pdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Problems that can be found in malformed PDB files. They're wrapped
+// in a *FormatError that says where the problem is, and can be
+// checked for with errors.Is.
+var (
+	// ErrTruncated means the file ends before something that should
+	// be in it, such as the header, a record list, or the start of
+	// a record.
+	ErrTruncated = errors.New("file truncated")
+	// ErrOverlappingRecords means a record or info block starts
+	// inside the header or a record list, or in the same place as an
+	// info block.
+	ErrOverlappingRecords = errors.New("overlapping records")
+	// ErrRecordListLoop means the chain of record lists loops back
+	// on itself.
+	ErrRecordListLoop = errors.New("record list chain loops")
+)
+
+// FormatError describes a structural problem with a PDB file.
+type FormatError struct {
+	// Index of the record or resource with the problem, or -1 if
+	// the problem isn't with a record.
+	Record int
+	// Offset in the file where the problem was found.
+	Offset int64
+	// The problem. This is usually one of the Err values in this
+	// package.
+	Err error
+}
+
+func (e *FormatError) Error() string {
+	if e.Record < 0 {
+		return fmt.Sprintf("pdb: offset %v: %v", e.Offset, e.Err)
+	}
+	return fmt.Sprintf("pdb: record %v at offset %v: %v", e.Record, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying problem.
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// formatError returns a *FormatError for record i at offset off.
+func formatError(i int, off int64, err error) error {
+	return &FormatError{Record: i, Offset: off, Err: err}
+}
+
+// ErrorList is a list of problems found in a PDB file. It's returned
+// when recovering a damaged file, and errors.Is and errors.As look
+// through all the problems in it.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no problems"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%v (and %v more problems)", l[0], len(l)-1)
+}
+
+// Unwrap returns the problems in the list.
+func (l ErrorList) Unwrap() []error {
+	return l
+}
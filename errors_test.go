@@ -0,0 +1,261 @@
+package pdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// threeRecords returns a well-formed PDB file with three records.
+func threeRecords(t *testing.T) []byte {
+	p := &Pdb{
+		Name:     "errors",
+		Filetype: "TEST",
+		Creator:  "TEST",
+		Records: []*Record{
+			{UniqueID: 1, Data: []byte("one")},
+			{UniqueID: 2, Data: []byte("two")},
+			{UniqueID: 3, Data: []byte("three")},
+		},
+	}
+	var b bytes.Buffer
+	if err := p.WriteFH(&b); err != nil {
+		t.Fatalf("Can't write test file: %v", err)
+	}
+	return b.Bytes()
+}
+
+// Offsets of things in the file from threeRecords.
+const (
+	listOffset    = 0x48
+	recordOffset0 = listOffset + 6
+	firstData     = recordOffset0 + 3*8
+	fileLen       = firstData + 3 + 3 + 5
+)
+
+func TestFormatErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		mangle     func([]byte) []byte
+		wantErr    error
+		wantRecord int
+		wantOffset int64
+	}{
+		{
+			name:       "short header",
+			mangle:     func(b []byte) []byte { return b[:40] },
+			wantErr:    ErrTruncated,
+			wantRecord: -1,
+			wantOffset: 40,
+		},
+		{
+			name:       "short record list",
+			mangle:     func(b []byte) []byte { return b[:recordOffset0+8+3] },
+			wantErr:    ErrTruncated,
+			wantRecord: 1,
+			wantOffset: recordOffset0 + 8,
+		},
+		{
+			name: "record past EOF",
+			mangle: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[recordOffset0+16:], fileLen+10)
+				return b
+			},
+			wantErr:    ErrTruncated,
+			wantRecord: 2,
+			wantOffset: fileLen + 10,
+		},
+		{
+			name: "record on appinfo",
+			mangle: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[0x34:], firstData+3)
+				return b
+			},
+			wantErr:    ErrOverlappingRecords,
+			wantRecord: 1,
+			wantOffset: firstData + 3,
+		},
+		{
+			name: "record inside record list",
+			mangle: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[recordOffset0:], recordOffset0+4)
+				return b
+			},
+			wantErr:    ErrOverlappingRecords,
+			wantRecord: 0,
+			wantOffset: recordOffset0 + 4,
+		},
+		{
+			name: "appinfo past EOF",
+			mangle: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[0x34:], fileLen)
+				return b
+			},
+			wantErr:    ErrTruncated,
+			wantRecord: -1,
+			wantOffset: fileLen,
+		},
+		{
+			name: "record list loop",
+			mangle: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[listOffset:], listOffset)
+				return b
+			},
+			wantErr:    ErrRecordListLoop,
+			wantRecord: -1,
+			wantOffset: listOffset,
+		},
+	}
+
+	for _, test := range tests {
+		raw := test.mangle(threeRecords(t))
+
+		_, err := ReadFH(bytes.NewReader(raw))
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%v: got error %v, want %v", test.name, err, test.wantErr)
+			continue
+		}
+		var fe *FormatError
+		if !errors.As(err, &fe) {
+			t.Errorf("%v: error %v isn't a *FormatError", test.name, err)
+			continue
+		}
+		if fe.Record != test.wantRecord || fe.Offset != test.wantOffset {
+			t.Errorf("%v: got record %v offset %v, want record %v offset %v", test.name, fe.Record, fe.Offset, test.wantRecord, test.wantOffset)
+		}
+
+		f := bytes.NewReader(raw)
+		if _, err := Open(f, f.Size()); !errors.Is(err, test.wantErr) {
+			t.Errorf("%v: Open got error %v, want %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name    string
+		mangle  func([]byte) []byte
+		wantErr error
+		// Data we expect to get back for each record.
+		want []string
+	}{
+		{
+			name:   "undamaged",
+			mangle: func(b []byte) []byte { return b },
+			want:   []string{"one", "two", "three"},
+		},
+		{
+			name:    "truncated tail",
+			mangle:  func(b []byte) []byte { return b[:firstData+4] },
+			wantErr: ErrTruncated,
+			want:    []string{"one", "t", ""},
+		},
+		{
+			name:    "short record list",
+			mangle:  func(b []byte) []byte { return b[:recordOffset0+8+3] },
+			wantErr: ErrTruncated,
+			want:    []string{""},
+		},
+		{
+			name: "record inside record list",
+			mangle: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[recordOffset0:], recordOffset0+4)
+				return b
+			},
+			wantErr: ErrOverlappingRecords,
+			want:    []string{"", "two", "three"},
+		},
+		{
+			// Records that share a start aren't a problem; the
+			// first is just empty.
+			name: "shared start",
+			mangle: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[recordOffset0+8:], firstData)
+				return b
+			},
+			want: []string{"", "onetwo", "three"},
+		},
+		{
+			name: "record list loop",
+			mangle: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[listOffset:], listOffset)
+				return b
+			},
+			wantErr: ErrRecordListLoop,
+			want:    []string{"one", "two", "three"},
+		},
+		{
+			name: "appinfo past EOF",
+			mangle: func(b []byte) []byte {
+				binary.BigEndian.PutUint32(b[0x34:], fileLen)
+				return b
+			},
+			wantErr: ErrTruncated,
+			want:    []string{"one", "two", "three"},
+		},
+	}
+
+	for _, test := range tests {
+		raw := test.mangle(threeRecords(t))
+		p, err := ReadFHOptions(bytes.NewReader(raw), ReadOptions{Recover: true})
+		if p == nil {
+			t.Errorf("%v: got no Pdb back (error %v)", test.name, err)
+			continue
+		}
+		if test.wantErr == nil && err != nil {
+			t.Errorf("%v: got error %v, want none", test.name, err)
+		}
+		if test.wantErr != nil {
+			var problems ErrorList
+			if !errors.As(err, &problems) {
+				t.Errorf("%v: error %v isn't an ErrorList", test.name, err)
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("%v: got error %v, want %v", test.name, err, test.wantErr)
+			}
+		}
+
+		var got []string
+		for _, r := range p.Records {
+			got = append(got, string(r.Data))
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%v: got records %q, want %q", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%v: got records %q, want %q", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+// TestEmptyRecord checks a file with an empty record, which starts
+// where the next record does, reads without error.
+func TestEmptyRecord(t *testing.T) {
+	raw := threeRecords(t)
+	// Move record 1 to where record 2 starts, making it empty and
+	// leaving its old data at the end of record 0.
+	binary.BigEndian.PutUint32(raw[recordOffset0+8:], firstData+6)
+
+	p, err := ReadFH(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadFH error: %v", err)
+	}
+	f, err := Open(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatalf("Open error: %v", err)
+	}
+	for i, want := range []string{"onetwo", "", "three"} {
+		if got := string(p.Records[i].Data); got != want {
+			t.Errorf("ReadFH record %v: got %q, want %q", i, got, want)
+		}
+		d, err := f.RecordData(i)
+		if err != nil || string(d) != want {
+			t.Errorf("Open record %v: got %q/%v, want %q", i, d, err, want)
+		}
+	}
+}
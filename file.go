@@ -0,0 +1,136 @@
+package pdb
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// File is a PDB file whose record data is read on demand rather than
+// all at once. Only the header, record list, and info blocks are
+// read when the file is opened. It's safe to fetch records from
+// multiple goroutines at once.
+type File struct {
+	// Pdb holds the header and record list of the file. The Data
+	// field of its records and resources is left empty; use
+	// RecordData and ResourceData to get at their contents.
+	*Pdb
+
+	r io.ReaderAt
+
+	mu sync.Mutex
+	// Maximum number of records to keep in the cache. 0 means no
+	// caching.
+	cacheSize int
+	// Cached record data, keyed by record or resource index, with
+	// the most recently used entry at the front of lru.
+	cache map[int]*list.Element
+	lru   *list.List
+}
+
+// cacheEntry is a single record's data in the File cache.
+type cacheEntry struct {
+	index int
+	data  []byte
+}
+
+// Open parses the header, record list, and AppInfo and SortInfo
+// blocks of the size byte long PDB file available from r. Record data
+// is read from r as it's asked for, so r must stay usable for as long
+// as the File is.
+func Open(r io.ReaderAt, size int64) (*File, error) {
+	sr := io.NewSectionReader(r, 0, size)
+	p, err := parse(sr, size, false)
+	if err != nil {
+		return nil, err
+	}
+	// The info blocks are small and not records, so we just read
+	// them now.
+	if err := p.readInfoData(sr); err != nil {
+		return nil, err
+	}
+	return &File{Pdb: p, r: r}, nil
+}
+
+// SetCacheSize sets the number of records whose data will be kept in
+// memory after being read. The least recently used records are
+// dropped first. A size of 0, the default, turns caching off.
+func (f *File) SetCacheSize(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cacheSize = n
+	if f.cache == nil {
+		f.cache = make(map[int]*list.Element)
+		f.lru = list.New()
+	}
+	f.trimCache()
+}
+
+// trimCache drops records from the cache until it's no bigger than
+// the cache size. f.mu must be held.
+func (f *File) trimCache() {
+	for f.lru != nil && f.lru.Len() > f.cacheSize {
+		e := f.lru.Back()
+		f.lru.Remove(e)
+		delete(f.cache, e.Value.(*cacheEntry).index)
+	}
+}
+
+// RecordData returns the contents of record i. The returned slice may
+// be shared with the cache and other callers, so it must not be
+// modified.
+func (f *File) RecordData(i int) ([]byte, error) {
+	if i < 0 || i >= len(f.Records) {
+		return nil, fmt.Errorf("Record %v out of range; file has %v records", i, len(f.Records))
+	}
+	return f.readEntry(i, &f.Records[i].extent)
+}
+
+// ResourceData returns the contents of resource i. The returned slice
+// may be shared with the cache and other callers, so it must not be
+// modified.
+func (f *File) ResourceData(i int) ([]byte, error) {
+	if i < 0 || i >= len(f.Resources) {
+		return nil, fmt.Errorf("Resource %v out of range; file has %v resources", i, len(f.Resources))
+	}
+	return f.readEntry(i, &f.Resources[i].extent)
+}
+
+// readEntry returns the data for record list entry i, which lives at
+// e, going through the cache. Since a database has either records or
+// resources but never both the index alone is enough of a cache key.
+func (f *File) readEntry(i int, e *extent) ([]byte, error) {
+	f.mu.Lock()
+	if e, ok := f.cache[i]; ok {
+		f.lru.MoveToFront(e)
+		f.mu.Unlock()
+		return e.Value.(*cacheEntry).data, nil
+	}
+	f.mu.Unlock()
+
+	b := make([]byte, e.size())
+	n, err := f.r.ReadAt(b, int64(e.offset))
+	// ReadAt is allowed to return io.EOF along with a full read of the
+	// last entry in the file.
+	if n == len(b) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cacheSize > 0 {
+		// Someone else may have read the record while we weren't
+		// holding the lock.
+		if e, ok := f.cache[i]; ok {
+			f.lru.MoveToFront(e)
+			return e.Value.(*cacheEntry).data, nil
+		}
+		f.cache[i] = f.lru.PushFront(&cacheEntry{index: i, data: b})
+		f.trimCache()
+	}
+	return b, nil
+}
@@ -0,0 +1,95 @@
+package pdb
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"testing"
+)
+
+func openSample(t *testing.T) *File {
+	fh, err := os.Open(sampleFile)
+	if err != nil {
+		t.Fatalf("Unable to open %q: %v", sampleFile, err)
+	}
+	t.Cleanup(func() { fh.Close() })
+	st, err := fh.Stat()
+	if err != nil {
+		t.Fatalf("Unable to stat %q: %v", sampleFile, err)
+	}
+	f, err := Open(fh, st.Size())
+	if err != nil {
+		t.Fatalf("Open(%q): %v", sampleFile, err)
+	}
+	return f
+}
+
+func TestOpen(t *testing.T) {
+	f := openSample(t)
+	if f.Name != "Alices_Adven-_in_Wonderland" {
+		t.Errorf("Name: got %q, want %q", f.Name, "Alices_Adven-_in_Wonderland")
+	}
+	for i, r := range f.Records {
+		if r.Data != nil {
+			t.Errorf("Record[%v].Data loaded by Open", i)
+			break
+		}
+	}
+
+	p, err := Read(sampleFile)
+	if err != nil {
+		t.Fatalf("Unable to read %q: %v", sampleFile, err)
+	}
+	if len(f.Records) != len(p.Records) {
+		t.Fatalf("Records: got %v, want %v", len(f.Records), len(p.Records))
+	}
+	for i, r := range p.Records {
+		got, err := f.RecordData(i)
+		if err != nil {
+			t.Errorf("RecordData(%v): %v", i, err)
+			continue
+		}
+		if !bytes.Equal(got, r.Data) {
+			t.Errorf("RecordData(%v) doesn't match the data from Read", i)
+		}
+	}
+
+	if _, err := f.RecordData(len(f.Records)); err == nil {
+		t.Errorf("RecordData(%v) past the last record didn't fail", len(f.Records))
+	}
+}
+
+func TestOpenConcurrentCache(t *testing.T) {
+	f := openSample(t)
+	f.SetCacheSize(8)
+
+	p, err := Read(sampleFile)
+	if err != nil {
+		t.Fatalf("Unable to read %q: %v", sampleFile, err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				i := (g*31 + n*7) % len(p.Records)
+				got, err := f.RecordData(i)
+				if err != nil {
+					t.Errorf("RecordData(%v): %v", i, err)
+					return
+				}
+				if !bytes.Equal(got, p.Records[i].Data) {
+					t.Errorf("RecordData(%v) doesn't match the data from Read", i)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if l := f.lru.Len(); l > 8 {
+		t.Errorf("cache holds %v records, want at most 8", l)
+	}
+}
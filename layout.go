@@ -0,0 +1,214 @@
+package pdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// blockKind says what a block in the file holds.
+type blockKind int
+
+const (
+	headerBlock blockKind = iota
+	recordListBlock
+	appInfoBlock
+	sortInfoBlock
+	entryBlock
+	gapBlock
+)
+
+// block is a single contiguous chunk of a PDB file.
+type block struct {
+	kind blockKind
+	// For record lists, the index of the first entry in the list
+	// and the number of entries it holds. For records and
+	// resources, the entry index.
+	index int
+	count int
+	// For gaps, the bytes that were in the gap.
+	gap []byte
+	// Where the block starts in the file.
+	offset uint32
+	// For record lists, the offset of the next list in the chain, or
+	// 0 if this is the last one.
+	next uint32
+	// How big the block is. Only used while the layout is being
+	// captured.
+	size int
+}
+
+// layout describes the order of everything in a PDB file, along with
+// any bytes that didn't belong to anything. It's kept around when a
+// file is read with PreserveLayout so the file can be written back
+// out exactly the way it was found. Files without a preserved layout
+// are written using defaultLayout.
+type layout struct {
+	// The header as it was read, so bytes that don't survive being
+	// decoded (junk after the name's NUL, 1904-relative times) can
+	// be put back.
+	header header
+	// The epoch the header's times were read in. The raw times are
+	// only put back if the caller hasn't picked a different one.
+	epoch Epoch
+	// The blocks in file order.
+	blocks []block
+}
+
+// captureLayout records the layout of the file p was read from. It
+// must be called after all the data has been read.
+func (p *Pdb) captureLayout(fh io.ReadSeeker) error {
+	l := &layout{epoch: p.TimeEpoch}
+	if _, err := fh.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Read(fh, binary.BigEndian, &l.header); err != nil {
+		return err
+	}
+
+	blocks := []block{{kind: headerBlock, offset: 0, size: 0x48}}
+	first := 0
+	for _, rl := range p.lists {
+		blocks = append(blocks, block{kind: recordListBlock, index: first, count: rl.count, offset: rl.offset, size: 6 + rl.count*p.entrySize()})
+		first += rl.count
+	}
+	if len(p.AppInfo) > 0 {
+		blocks = append(blocks, block{kind: appInfoBlock, offset: p.appInfoOffset, size: len(p.AppInfo)})
+	}
+	if len(p.SortInfo) > 0 {
+		blocks = append(blocks, block{kind: sortInfoBlock, offset: p.sortInfoOffset, size: len(p.SortInfo)})
+	}
+	for i, e := range p.extents() {
+		blocks = append(blocks, block{kind: entryBlock, index: i, offset: e.offset, size: e.size()})
+	}
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].offset < blocks[j].offset })
+
+	// Walk through the blocks in file order, noting any bytes that
+	// fall between them.
+	pos := int64(0)
+	for _, b := range blocks {
+		if int64(b.offset) < pos {
+			return fmt.Errorf("Can't preserve layout: block at offset %v overlaps the one before it", b.offset)
+		}
+		if int64(b.offset) > pos {
+			g, err := readExtent(fh, &extent{uint32(pos), b.offset - 1})
+			if err != nil {
+				return err
+			}
+			l.blocks = append(l.blocks, block{kind: gapBlock, gap: g, offset: uint32(pos)})
+		}
+		l.blocks = append(l.blocks, b)
+		pos = int64(b.offset) + int64(b.size)
+	}
+	if pos < p.totalSize {
+		g, err := readExtent(fh, &extent{uint32(pos), uint32(p.totalSize - 1)})
+		if err != nil {
+			return err
+		}
+		l.blocks = append(l.blocks, block{kind: gapBlock, gap: g, offset: uint32(pos)})
+	}
+
+	p.layout = l
+	return nil
+}
+
+// fits returns true if the layout can still be used to write out p.
+// That's the case as long as the number of records hasn't changed and
+// the AppInfo and SortInfo blocks are still there (or not) like they
+// were when the file was read.
+func (l *layout) fits(p *Pdb) bool {
+	var listEntries, entries int
+	var appInfo, sortInfo bool
+	for _, b := range l.blocks {
+		switch b.kind {
+		case recordListBlock:
+			listEntries += b.count
+		case entryBlock:
+			entries++
+		case appInfoBlock:
+			appInfo = true
+		case sortInfoBlock:
+			sortInfo = true
+		}
+	}
+	n := p.entryCount()
+	return listEntries == n && entries == n && appInfo == (len(p.AppInfo) > 0) && sortInfo == (len(p.SortInfo) > 0)
+}
+
+// restoreHeader puts back any raw header fields whose decoded values
+// haven't been changed. Times are only put back if they're still
+// being written in the epoch they were read in.
+func (l *layout) restoreHeader(p *Pdb, h *header) {
+	raw := &l.header
+	if p.Name == headerName(raw.Name) {
+		h.Name = raw.Name
+	}
+	if p.TimeEpoch != l.epoch {
+		return
+	}
+	if p.CreateTime.Equal(readTime(raw.Create)) {
+		h.Create = raw.Create
+	}
+	if p.ModTime.Equal(readTime(raw.Modified)) {
+		h.Modified = raw.Modified
+	}
+	if p.BackupTime.Equal(readTime(raw.Backup)) {
+		h.Backup = raw.Backup
+	}
+}
+
+// defaultLayout returns the layout used when there isn't a
+// preserved one to follow: the header, the record lists, the AppInfo
+// and SortInfo blocks, then the records or resources in record list
+// order.
+func (p *Pdb) defaultLayout() []block {
+	entries := p.entryCount()
+	blocks := []block{{kind: headerBlock}}
+	// If we have more than 64K records they get chained across
+	// multiple lists.
+	for l := 0; l < recordListCount(entries); l++ {
+		first := l * maxListRecords
+		count := entries - first
+		if count > maxListRecords {
+			count = maxListRecords
+		}
+		blocks = append(blocks, block{kind: recordListBlock, index: first, count: count})
+	}
+	// Two bytes of padding if there aren't any records
+	if entries == 0 {
+		blocks = append(blocks, block{kind: gapBlock, gap: []byte{0, 0}})
+	}
+	if len(p.AppInfo) > 0 {
+		blocks = append(blocks, block{kind: appInfoBlock})
+	}
+	if len(p.SortInfo) > 0 {
+		blocks = append(blocks, block{kind: sortInfoBlock})
+	}
+	for i := 0; i < entries; i++ {
+		blocks = append(blocks, block{kind: entryBlock, index: i})
+	}
+	return blocks
+}
+
+// entryData returns the data for all the records or resources in the
+// database, in record list order.
+func (p *Pdb) entryData() [][]byte {
+	d := make([][]byte, 0, len(p.Records)+len(p.Resources))
+	for _, r := range p.Records {
+		d = append(d, r.Data)
+	}
+	for _, r := range p.Resources {
+		d = append(d, r.Data)
+	}
+	return d
+}
+
+// dataSizes returns the length of each slice in data.
+func dataSizes(data [][]byte) []int {
+	s := make([]int, len(data))
+	for i, d := range data {
+		s[i] = len(d)
+	}
+	return s
+}
@@ -0,0 +1,200 @@
+package pdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// oddLayout builds a PDB file with a layout WriteFH would never
+// produce on its own: junk after the name, 1904-relative times, junk
+// between the record list and the data, SortInfo before AppInfo, and
+// records stored in the opposite order to the record list.
+func oddLayout() []byte {
+	var b bytes.Buffer
+	h := header{
+		Create:   0xd5a0c4b5,
+		Modified: 0xd5a0c4b6,
+		Filetype: [4]byte{'T', 'E', 'S', 'T'},
+		Creator:  [4]byte{'T', 'E', 'S', 'T'},
+	}
+	copy(h.Name[:], "odd\x00junk")
+
+	// Header, a record list with two records, and a 5 byte gap.
+	listEnd := uint32(0x48 + 6 + 2*8)
+	sortInfo := []byte("sorted")
+	appInfo := []byte("application info")
+	rec0 := []byte("record zero")
+	rec1 := []byte("record one")
+	h.SortInfoOffset = listEnd + 5
+	h.AppInfoOffset = h.SortInfoOffset + uint32(len(sortInfo))
+	rec1Offset := h.AppInfoOffset + uint32(len(appInfo))
+	rec0Offset := rec1Offset + uint32(len(rec1))
+
+	binary.Write(&b, binary.BigEndian, &h)
+	binary.Write(&b, binary.BigEndian, []uint32{0})
+	binary.Write(&b, binary.BigEndian, uint16(2))
+	binary.Write(&b, binary.BigEndian, []uint32{rec0Offset, 0x40000007, rec1Offset, 0x00000009})
+	b.Write([]byte{0, 0, 'g', 'a', 'p'})
+	b.Write(sortInfo)
+	b.Write(appInfo)
+	b.Write(rec1)
+	b.Write(rec0)
+	return b.Bytes()
+}
+
+func TestPreserveLayout(t *testing.T) {
+	sample, err := ioutil.ReadFile(sampleFile)
+	if err != nil {
+		t.Fatalf("Unable to read %q: %v", sampleFile, err)
+	}
+
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"sample", sample},
+		{"odd layout", oddLayout()},
+	}
+
+	for _, test := range tests {
+		p, err := ReadFHOptions(bytes.NewReader(test.raw), ReadOptions{PreserveLayout: true})
+		if err != nil {
+			t.Errorf("%v: can't read: %v", test.name, err)
+			continue
+		}
+		var b bytes.Buffer
+		if err := p.WriteFH(&b); err != nil {
+			t.Errorf("%v: can't write: %v", test.name, err)
+			continue
+		}
+		if got := b.Bytes(); !bytes.Equal(got, test.raw) {
+			t.Errorf("%v: round trip differs at offset %v", test.name, firstDiff(got, test.raw))
+		}
+	}
+}
+
+func TestPreserveLayoutResized(t *testing.T) {
+	raw := oddLayout()
+	p, err := ReadFHOptions(bytes.NewReader(raw), ReadOptions{PreserveLayout: true})
+	if err != nil {
+		t.Fatalf("Can't read: %v", err)
+	}
+	if string(p.AppInfo) != "application info" || string(p.SortInfo) != "sorted" {
+		t.Errorf("Info blocks: got %q/%q", p.AppInfo, p.SortInfo)
+	}
+
+	// Record 1 is stored first, so growing it moves record 0 along.
+	p.Records[1].Data = []byte("record one, now longer")
+	p.Name = "renamed"
+
+	var b bytes.Buffer
+	if err := p.WriteFH(&b); err != nil {
+		t.Fatalf("Can't write: %v", err)
+	}
+	got := b.Bytes()
+	if want := len(raw) + 12; len(got) != want {
+		t.Errorf("Length: got %v, want %v", len(got), want)
+	}
+	// Everything from the record list up to record 1, including the
+	// gap, is unchanged apart from record 0's offset.
+	if !bytes.Equal(got[0x48:0x4e], raw[0x48:0x4e]) || !bytes.Equal(got[0x52:0x5e], raw[0x52:0x5e]) {
+		t.Errorf("Record list changed unexpectedly")
+	}
+	if !bytes.Equal(got[0x5e:0x5e+5+6+16], raw[0x5e:0x5e+5+6+16]) {
+		t.Errorf("Gap and info blocks changed unexpectedly")
+	}
+	// The 1904 times were preserved too.
+	if !bytes.Equal(got[0x24:0x2c], raw[0x24:0x2c]) {
+		t.Errorf("Times: got %x, want %x", got[0x24:0x2c], raw[0x24:0x2c])
+	}
+
+	np, err := ReadFH(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("Can't re-read: %v", err)
+	}
+	if np.Name != "renamed" {
+		t.Errorf("Name: got %q, want %q", np.Name, "renamed")
+	}
+	for i, r := range p.Records {
+		if !bytes.Equal(np.Records[i].Data, r.Data) {
+			t.Errorf("Record[%v].Data: got %q, want %q", i, np.Records[i].Data, r.Data)
+		}
+	}
+}
+
+func TestPreserveLayoutDiscarded(t *testing.T) {
+	p, err := ReadFHOptions(bytes.NewReader(oddLayout()), ReadOptions{PreserveLayout: true})
+	if err != nil {
+		t.Fatalf("Can't read: %v", err)
+	}
+	p.Records = append(p.Records, &Record{UniqueID: 10, Data: []byte("record two")})
+
+	var b bytes.Buffer
+	if err := p.WriteFH(&b); err != nil {
+		t.Fatalf("Can't write: %v", err)
+	}
+	np, err := ReadFH(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("Can't re-read: %v", err)
+	}
+	if len(np.Records) != 3 {
+		t.Fatalf("Records: got %v, want 3", len(np.Records))
+	}
+	for i, r := range p.Records {
+		if !bytes.Equal(np.Records[i].Data, r.Data) {
+			t.Errorf("Record[%v].Data: got %q, want %q", i, np.Records[i].Data, r.Data)
+		}
+	}
+}
+
+// firstDiff returns the offset of the first byte where l and r differ.
+func firstDiff(l, r []byte) int {
+	i := 0
+	for i < len(l) && i < len(r) && l[i] == r[i] {
+		i++
+	}
+	return i
+}
+
+// TestPreserveLayoutEpoch checks that changing the epoch of a file
+// read with PreserveLayout changes how its times are written.
+func TestPreserveLayoutEpoch(t *testing.T) {
+	sample, err := ioutil.ReadFile(sampleFile)
+	if err != nil {
+		t.Fatalf("Unable to read %q: %v", sampleFile, err)
+	}
+	p, err := ReadFHOptions(bytes.NewReader(sample), ReadOptions{PreserveLayout: true})
+	if err != nil {
+		t.Fatalf("Can't read: %v", err)
+	}
+	if p.TimeEpoch != UnixEpoch {
+		t.Fatalf("TimeEpoch: got %v, want %v", p.TimeEpoch, UnixEpoch)
+	}
+	create, mod := p.CreateTime, p.ModTime
+	p.TimeEpoch = PalmEpoch
+
+	var b bytes.Buffer
+	if err := p.WriteFH(&b); err != nil {
+		t.Fatalf("Can't write: %v", err)
+	}
+	raw := b.Bytes()
+	if want := writeTime(create, PalmEpoch); binary.BigEndian.Uint32(raw[0x24:]) != want {
+		t.Errorf("Raw create time: got %#x, want %#x", binary.BigEndian.Uint32(raw[0x24:]), want)
+	}
+	np, err := ReadFH(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Can't re-read: %v", err)
+	}
+	if np.TimeEpoch != PalmEpoch {
+		t.Errorf("TimeEpoch: got %v, want %v", np.TimeEpoch, PalmEpoch)
+	}
+	if !np.CreateTime.Equal(create) || !np.ModTime.Equal(mod) {
+		t.Errorf("Times: got %v/%v, want %v/%v", np.CreateTime, np.ModTime, create, mod)
+	}
+	// Everything but the times still matches.
+	if !bytes.Equal(raw[:0x24], sample[:0x24]) || !bytes.Equal(raw[0x30:], sample[0x30:]) {
+		t.Errorf("Round trip differs outside the times")
+	}
+}
@@ -0,0 +1,223 @@
+package lz77
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// refDecompress is a reference decoder written straight from the
+// PalmDoc format description, sharing nothing with Decompress, so the
+// two can be checked against each other. It returns false if the
+// data is malformed.
+//
+// Each byte b of the input is one of:
+//
+//	0x00, 0x09-0x7f  the byte itself
+//	0x01-0x08        b raw bytes follow
+//	0x80-0xbf        with the next byte, a 16 bit big endian value;
+//	                 bits 3-13 are the distance back to copy from and
+//	                 bits 0-2 are the length minus 3
+//	0xc0-0xff        a space followed by b xor 0x80
+//
+// A distance of 0 is read as 1, the way Decompress always has.
+func refDecompress(in []byte) ([]byte, bool) {
+	var out []byte
+	for len(in) > 0 {
+		b := in[0]
+		in = in[1:]
+		switch {
+		case b == 0x00 || (b >= 0x09 && b <= 0x7f):
+			out = append(out, b)
+		case b <= 0x08:
+			if len(in) < int(b) {
+				return nil, false
+			}
+			out = append(out, in[:b]...)
+			in = in[b:]
+		case b <= 0xbf:
+			if len(in) < 1 {
+				return nil, false
+			}
+			v := uint16(b)<<8 | uint16(in[0])
+			in = in[1:]
+			dist := int(v>>3) & 0x7ff
+			length := int(v&7) + 3
+			if dist == 0 {
+				dist = 1
+			}
+			if dist > len(out) {
+				return nil, false
+			}
+			from := len(out) - dist
+			for k := 0; k < length; k++ {
+				out = append(out, out[from+k])
+			}
+		default:
+			out = append(out, ' ', b^0x80)
+		}
+	}
+	return out, true
+}
+
+// maxCompressed is the most a block of n bytes can compress to.
+// Bytes that can't be sent as they are go out in raw runs of up to 8,
+// each costing a byte more than the bytes in it. Every other token
+// is no bigger than what it encodes.
+func maxCompressed(n int) int {
+	return n + (n+7)/8 + 1
+}
+
+// generators make inputs of different kinds for the property tests.
+var generators = map[string]func(r *rand.Rand, n int) []byte{
+	"random": func(r *rand.Rand, n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	},
+	"text": func(r *rand.Rand, n int) []byte {
+		const alphabet = "etaoin shrdlu ETAOIN.,\n"
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = alphabet[r.Intn(len(alphabet))]
+		}
+		return b
+	},
+	"low and high bytes": func(r *rand.Rand, n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			switch r.Intn(3) {
+			case 0:
+				b[i] = byte(r.Intn(9))
+			case 1:
+				b[i] = byte(0x80 + r.Intn(0x80))
+			default:
+				b[i] = ' '
+			}
+		}
+		return b
+	},
+	"repeats": func(r *rand.Rand, n int) []byte {
+		var b []byte
+		for len(b) < n {
+			if len(b) > 0 && r.Intn(2) == 0 {
+				// Copy a piece of what's there, possibly overlapping
+				// the end.
+				from := r.Intn(len(b))
+				l := 1 + r.Intn(20)
+				for k := 0; k < l; k++ {
+					b = append(b, b[from+k])
+				}
+				continue
+			}
+			b = append(b, byte(r.Intn(256)))
+		}
+		return b[:n]
+	},
+}
+
+// checkRoundTrip compresses in at every level and checks the result
+// decompresses to in with both decoders and is no bigger than it can
+// be.
+func checkRoundTrip(t *testing.T, name string, in []byte) {
+	bound := 0
+	for start := 0; start < len(in); start += blockSize {
+		end := start + blockSize
+		if end > len(in) {
+			end = len(in)
+		}
+		bound += maxCompressed(end - start)
+	}
+
+	for _, level := range levels {
+		c, err := CompressLevel(in, level)
+		if err != nil {
+			t.Fatalf("%v, level %v: CompressLevel error: %v", name, level, err)
+		}
+		if len(c) > bound {
+			t.Errorf("%v, level %v: %v bytes compressed to %v, more than %v", name, level, len(in), len(c), bound)
+		}
+		ref, ok := refDecompress(c)
+		if !ok {
+			t.Errorf("%v, level %v: reference decoder rejected output", name, level)
+		} else if !bytes.Equal(ref, in) {
+			t.Errorf("%v, level %v: reference decoder output doesn't match input", name, level)
+		}
+		got, err := Decompress(c)
+		if err != nil {
+			t.Errorf("%v, level %v: Decompress error: %v", name, level, err)
+		} else if !bytes.Equal(got, in) {
+			t.Errorf("%v, level %v: Decompress output doesn't match input", name, level)
+		}
+	}
+}
+
+func TestRoundTripProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for name, gen := range generators {
+		for i := 0; i < 50; i++ {
+			n := r.Intn(3 * blockSize)
+			checkRoundTrip(t, name, gen(r, n))
+		}
+		// Lengths right around the block size.
+		for n := blockSize - 2; n <= blockSize+2; n++ {
+			checkRoundTrip(t, name, gen(r, n))
+		}
+	}
+}
+
+// TestReferenceDecoder checks the two decoders agree on some real
+// compressed text and on random garbage.
+func TestReferenceDecoder(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	inputs := [][]byte{mustCompress(t, data)}
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 1000; i++ {
+		inputs = append(inputs, generators["random"](r, r.Intn(50)))
+	}
+	for _, in := range inputs {
+		checkDecoders(t, in)
+	}
+}
+
+// checkDecoders checks Decompress and the other ways of decoding data
+// agree with the reference decoder.
+func checkDecoders(t *testing.T, data []byte) {
+	want, ok := refDecompress(data)
+	got, err := Decompress(data)
+	if ok != (err == nil) {
+		t.Fatalf("Decompress(%v) error %v, reference decoder ok %v", data, err, ok)
+	}
+	if !ok {
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Decompress(%v) got %v, reference decoder %v", data, got, want)
+	}
+
+	got, err = ioutil.ReadAll(NewReader(bytes.NewReader(data)))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("Reader on %v got %v/%v, want %v", data, got, err, want)
+	}
+	if n, err := decodedLen(data); err != nil || n != len(want) {
+		t.Fatalf("decodedLen(%v) got %v/%v, want %v", data, n, err, len(want))
+	}
+	// Strict decoding accepts a subset of what Decompress does.
+	if got, err := DecompressStrict(data); err == nil && !bytes.Equal(got, want) {
+		t.Fatalf("DecompressStrict(%v) got %v, want %v", data, got, want)
+	}
+}
+
+func FuzzCompress(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("hello hello hello world"))
+	f.Add([]byte{0x00, 0x01, 0x80, 0xff, ' ', 'A'})
+	f.Add(bytes.Repeat([]byte("ab "), 50))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, "fuzz", data)
+	})
+}
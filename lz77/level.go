@@ -0,0 +1,160 @@
+package lz77
+
+// isLiteral returns true if b can be sent through without any
+// encoding.
+func isLiteral(b byte) bool {
+	return b == 0 || (b >= 0x09 && b <= 0x7f)
+}
+
+// isPair returns true if the bytes at i can be sent as a space pair.
+func isPair(data []byte, i int) bool {
+	return data[i] == ' ' && i+1 < len(data) && data[i+1] >= 0x40 && data[i+1] <= 0x7f
+}
+
+// parser holds the state for compressing a block. BestSpeed only
+// uses the matcher. The slower levels need to know the longest run at
+// every position of a block before they decide how to encode it.
+type parser struct {
+	m matcher
+	// The longest run starting at each position and how far back it
+	// starts. A length of 0 means there's no run.
+	runLen  [blockSize]uint8
+	runDist [blockSize]uint16
+	// For BestCompression, the cheapest encoding of the data from
+	// each position to the end of the block, and the kind and length
+	// of the first token in it.
+	cost    [blockSize + 1]int32
+	tokKind [blockSize]uint8
+	tokLen  [blockSize]uint8
+}
+
+// findRuns fills in the longest run at every position of data. The
+// slower levels can look the whole way back and let runs overlap
+// the data they copy to.
+func (p *parser) findRuns(data []byte) {
+	p.m.reset(data, maxDist, true)
+	for i := range data {
+		l, dist := p.m.findRun(i)
+		if l < 3 {
+			l, dist = 0, 0
+		}
+		p.runLen[i] = uint8(l)
+		p.runDist[i] = uint16(dist)
+	}
+}
+
+// appendRun adds a back reference of l bytes from dist back to ret.
+func appendRun(ret []byte, l, dist int) []byte {
+	word := uint16(dist<<3+(l-3)) | 0x8000
+	return append(ret, byte(word>>8), byte(word&0xff))
+}
+
+// compressLazy compresses a block for LazyMatching and appends it to
+// ret. It works like BestSpeed, except that a run is passed over if a
+// longer one starts at the next byte or right after a space pair.
+func (p *parser) compressLazy(ret, data []byte) []byte {
+	p.findRuns(data)
+	for i := 0; i < len(data); {
+		l := int(p.runLen[i])
+		if l >= 3 {
+			// A longer run at the next byte? Send this one on its own
+			// and take that instead.
+			if i+1 < len(data) && int(p.runLen[i+1]) > l {
+				ret = appendLiteral(ret, data[i])
+				i++
+				continue
+			}
+			// A space pair followed by a longer run beats taking this
+			// run.
+			if isPair(data, i) && i+2 < len(data) && int(p.runLen[i+2]) > l {
+				ret = append(ret, 0x80^data[i+1])
+				i += 2
+				continue
+			}
+			ret = appendRun(ret, l, int(p.runDist[i]))
+			i += l
+			continue
+		}
+
+		if isPair(data, i) {
+			ret = append(ret, 0x80^data[i+1])
+			i += 2
+			continue
+		}
+
+		if isLiteral(data[i]) {
+			ret = append(ret, data[i])
+			i++
+			continue
+		}
+
+		// Send as many bytes raw as we can, stopping if a run
+		// starts. Literals cost the same either way, and taking them
+		// along saves starting another raw run after them.
+		n := 1
+		for n < 8 && i+n < len(data) && p.runLen[i+n] == 0 {
+			n++
+		}
+		ret = append(ret, byte(n))
+		ret = append(ret, data[i:i+n]...)
+		i += n
+	}
+
+	return ret
+}
+
+// compressOptimal compresses a block for BestCompression and appends
+// it to ret. Working back from the end of the block it finds the
+// cheapest way to encode the rest of the block from each position,
+// trying every token that could start there, then sends the cheapest
+// encoding from the start.
+func (p *parser) compressOptimal(ret, data []byte) []byte {
+	p.findRuns(data)
+
+	n := len(data)
+	p.cost[n] = 0
+	for i := n - 1; i >= 0; i-- {
+		// Sending bytes raw always works, so start with that.
+		best, kind, length := p.cost[i+1]+2, RawRun, 1
+		for k := 2; k <= 8 && i+k <= n; k++ {
+			if c := p.cost[i+k] + int32(k) + 1; c < best {
+				best, kind, length = c, RawRun, k
+			}
+		}
+		if isLiteral(data[i]) {
+			if c := p.cost[i+1] + 1; c < best {
+				best, kind, length = c, Literal, 1
+			}
+		}
+		if isPair(data, i) {
+			if c := p.cost[i+2] + 1; c < best {
+				best, kind, length = c, SpacePair, 2
+			}
+		}
+		// Any shorter piece of a run is a run too.
+		for k := 3; k <= int(p.runLen[i]); k++ {
+			if c := p.cost[i+k] + 2; c < best {
+				best, kind, length = c, BackRef, k
+			}
+		}
+		p.cost[i] = best
+		p.tokKind[i] = uint8(kind)
+		p.tokLen[i] = uint8(length)
+	}
+
+	for i := 0; i < n; i += int(p.tokLen[i]) {
+		switch TokenKind(p.tokKind[i]) {
+		case Literal:
+			ret = append(ret, data[i])
+		case SpacePair:
+			ret = append(ret, 0x80^data[i+1])
+		case RawRun:
+			ret = append(ret, p.tokLen[i])
+			ret = append(ret, data[i:i+int(p.tokLen[i])]...)
+		case BackRef:
+			ret = appendRun(ret, int(p.tokLen[i]), int(p.runDist[i]))
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,154 @@
+package lz77
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var levels = []int{BestSpeed, LazyMatching, BestCompression}
+
+func TestCompressLevelRoundTrip(t *testing.T) {
+	html, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	inputs := map[string][]byte{
+		"empty":      {},
+		"one byte":   {0x80},
+		"html":       html,
+		"binary":     randomJunk(1, 10000),
+		"repetitive": bytes.Repeat([]byte("aaaaaaaaaaaaaaab"), 1000),
+		"pairs":      []byte(strings.Repeat(" a b c  d \x01 \x80x", 500)),
+		"low bytes":  bytes.Repeat([]byte{1, 2, 3, 4, 'a', 5, 6, 7, 8, 9}, 700),
+	}
+
+	for name, in := range inputs {
+		for _, level := range levels {
+			c, err := CompressLevel(in, level)
+			if err != nil {
+				t.Errorf("%v, level %v: CompressLevel error: %v", name, level, err)
+				continue
+			}
+			got, err := Decompress(c)
+			if err != nil {
+				t.Errorf("%v, level %v: Decompress error: %v", name, level, err)
+				continue
+			}
+			if eq, fd := findDiff(got, in); !eq {
+				t.Errorf("%v, level %v: mismatch at %v", name, level, fd)
+			}
+		}
+	}
+}
+
+func TestCompressLevelBestSpeedMatchesCompress(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	want, _ := Compress(data)
+	got, err := CompressLevel(data, BestSpeed)
+	if err != nil {
+		t.Fatalf("CompressLevel error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("CompressLevel(BestSpeed) differs from Compress")
+	}
+}
+
+// TestBestCompressionIsSmallest checks that the optimal parse never
+// loses to the other levels on any block.
+func TestBestCompressionIsSmallest(t *testing.T) {
+	html, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	data := append(html, randomJunk(2, 5000)...)
+	data = append(data, []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200))...)
+
+	for start := 0; start < len(data); start += blockSize {
+		end := start + blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		block := data[start:end]
+		best := len(compressBlock(nil, block, BestCompression))
+		for _, level := range []int{BestSpeed, LazyMatching} {
+			if l := len(compressBlock(nil, block, level)); l < best {
+				t.Errorf("block at %v: level %v gave %v bytes, BestCompression %v", start, level, l, best)
+			}
+		}
+	}
+
+	fast, _ := CompressLevel(html, BestSpeed)
+	lazy, _ := CompressLevel(html, LazyMatching)
+	best, _ := CompressLevel(html, BestCompression)
+	t.Logf("ioutil.html: %v bytes, BestSpeed %v, LazyMatching %v, BestCompression %v", len(html), len(fast), len(lazy), len(best))
+	if len(lazy) > len(fast) {
+		t.Errorf("LazyMatching gave %v bytes, more than BestSpeed's %v", len(lazy), len(fast))
+	}
+}
+
+// TestLazyMatchingSize checks LazyMatching never does worse than
+// BestSpeed, and never makes a block bigger than sending it all in raw
+// runs of 8 would.
+func TestLazyMatchingSize(t *testing.T) {
+	html, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	inputs := map[string][]byte{
+		"html":      html,
+		"binary":    randomJunk(3, 11444),
+		"low bytes": bytes.Repeat([]byte{1, 2, 3, 4, 'a', 5, 6, 7, 8, 9}, 700),
+		"mixed":     bytes.Repeat([]byte{0x80, 'a', 0x81, 'b', 0x82, ' ', 0x83, 'c'}, 600),
+	}
+	for name, in := range inputs {
+		for start := 0; start < len(in); start += blockSize {
+			end := start + blockSize
+			if end > len(in) {
+				end = len(in)
+			}
+			block := in[start:end]
+			lazy := len(compressBlock(nil, block, LazyMatching))
+			if fast := len(compressBlock(nil, block, BestSpeed)); lazy > fast {
+				t.Errorf("%v, block at %v: LazyMatching gave %v bytes, more than BestSpeed's %v", name, start, lazy, fast)
+			}
+			if bound := len(block) + (len(block)+7)/8; lazy > bound {
+				t.Errorf("%v, block at %v: LazyMatching compressed %v bytes to %v, more than %v", name, start, len(block), lazy, bound)
+			}
+		}
+	}
+}
+
+func TestCompressLevelInvalid(t *testing.T) {
+	for _, level := range []int{0, -1, BestCompression + 1} {
+		if _, err := CompressLevel([]byte("abc"), level); err == nil {
+			t.Errorf("CompressLevel(%v) got no error, want one", level)
+		}
+	}
+}
+
+func BenchmarkCompressLevel(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		b.Fatalf("error reading test data: %v", err)
+	}
+	for _, bench := range []struct {
+		name  string
+		level int
+	}{
+		{"BestSpeed", BestSpeed},
+		{"LazyMatching", LazyMatching},
+		{"BestCompression", BestCompression},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				CompressLevel(data, bench.level)
+			}
+		})
+	}
+}
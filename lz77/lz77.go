@@ -3,66 +3,114 @@
 package lz77
 
 import (
-	"bytes"
 	"fmt"
-	"log"
+	"sync"
+)
+
+// Compression levels for CompressLevel.
+const (
+	// BestSpeed takes the longest run at each position as soon as it's
+	// found. This is what Compress does.
+	BestSpeed = 1
+	// LazyMatching holds off on a run if a longer one starts at the
+	// next byte, and uses space pairs where they let a run start
+	// sooner.
+	LazyMatching = 2
+	// BestCompression finds the smallest possible encoding of each
+	// block.
+	BestCompression = 3
 )
 
 // Compress takes a slice of bytes and returns a compressed version of
 // it. Compression is done in 4096 byte blocks for historical reasons.
 func Compress(data []byte) ([]byte, error) {
-	// Is our input less than one block? If so just compress it and be
-	// done. No need for chunking.
-	if len(data) <= 4096 {
-		return compressBlock(data), nil
+	return CompressLevel(data, BestSpeed)
+}
+
+// CompressLevel is like Compress, but lets the caller trade speed for
+// smaller output. level is one of BestSpeed, LazyMatching or
+// BestCompression. Every level produces output any PalmDoc reader can
+// decompress.
+func CompressLevel(data []byte, level int) ([]byte, error) {
+	// Preallocate the output slice on the optimistic assumption that
+	// the output won't be bigger than the input.
+	return AppendCompressLevel(make([]byte, 0, len(data)), data, level)
+}
+
+// AppendCompress compresses src like Compress does and appends the
+// result to dst, returning the extended slice. If dst has room for
+// the output nothing is allocated.
+func AppendCompress(dst, src []byte) []byte {
+	dst, _ = AppendCompressLevel(dst, src, BestSpeed)
+	return dst
+}
+
+// AppendCompressLevel is like AppendCompress, but compresses at the
+// given level.
+func AppendCompressLevel(dst, src []byte, level int) ([]byte, error) {
+	if err := checkLevel(level); err != nil {
+		return dst, err
 	}
 
-	ret := make([]byte, 0, len(data))
-	for start := 0; start < len(data); start += 4096 {
-		end := start + 4096
-		if end > len(data) {
-			end = len(data)
+	for start := 0; start < len(src); start += blockSize {
+		end := start + blockSize
+		if end > len(src) {
+			end = len(src)
 		}
-		c := compressBlock(data[start:end])
-		ret = append(ret, c...)
+		dst = compressBlock(dst, src[start:end], level)
 	}
 
-	return ret, nil
+	return dst, nil
 }
 
-// byteLiteral takes a byte and returns the lz77 encoded version of it.
-func byteLiteral(b byte) []byte {
-	switch {
-	case b == 0:
-		return []byte{b}
-	case b >= 0x09 && b <= 0x7f:
-		return []byte{b}
-	default:
-		return []byte{1, b}
+// appendLiteral appends the lz77 encoded version of a single byte to
+// ret.
+func appendLiteral(ret []byte, b byte) []byte {
+	if isLiteral(b) {
+		return append(ret, b)
 	}
+	return append(ret, 1, b)
 }
 
-// compressBlock compresses a single up-to-4096 byte block of the input.
-func compressBlock(data []byte) []byte {
-	// Preallocate the output slice on the optimistic assumption that
-	// the output won't be bigger than the input.
-	ret := make([]byte, 0, len(data))
+// checkLevel returns an error if level isn't a compression level.
+func checkLevel(level int) error {
+	if level < BestSpeed || level > BestCompression {
+		return fmt.Errorf("lz77: invalid compression level %v", level)
+	}
+	return nil
+}
+
+// parsers holds the compression state between blocks, since it's
+// too big to allocate for every block.
+var parsers = sync.Pool{
+	New: func() interface{} { return &parser{} },
+}
+
+// compressBlock compresses a single up-to-4096 byte block of the input
+// at the given level and appends it to ret.
+func compressBlock(ret, data []byte, level int) []byte {
+	p := parsers.Get().(*parser)
+	defer parsers.Put(p)
+	switch level {
+	case LazyMatching:
+		return p.compressLazy(ret, data)
+	case BestCompression:
+		return p.compressOptimal(ret, data)
+	}
+
+	m := &p.m
+	m.reset(data, window, false)
 	for i := 0; i < len(data); i++ {
 		// Last byte in the input? Encode it and be done.
 		if i == len(data)-1 {
-			ret = append(ret, byteLiteral(data[i])...)
+			ret = appendLiteral(ret, data[i])
 			continue
 		}
 
 		// Have we seen a run already? If so then encode it.
-		l, offset := findRun(data[i:], data[0:i])
+		l, offset := m.findRun(i)
 		if l >= 3 {
-			// 10 bytes is our maximum run length.
-			if l > 10 {
-				l = 10
-			}
-			word := uint16(offset<<3+(l-3)) | 0x8000
-			ret = append(ret, byte(word>>8), byte(word&0xff))
+			ret = appendRun(ret, l, offset)
 
 			i += (l - 1)
 			continue
@@ -96,83 +144,254 @@ func compressBlock(data []byte) []byte {
 	return ret
 }
 
-// findRun looks back in the data we've already compressed to see if
-// we can find a chunk that matches the data that's left to be compressed.
-func findRun(data []byte, seen []byte) (int, int) {
+const (
+	// Biggest block we compress at once.
+	blockSize = 4096
+	// How far back BestSpeed looks for runs. The offset is encoded in
+	// 11 bits so it could be up to 2047, but we've always used 1024.
+	window = 1024
+	// The furthest back a run can start.
+	maxDist = 2047
+	// Longest run we can encode.
+	maxRun = 10
+	// Number of bits in the hash of a 3 byte sequence.
+	hashBits = 12
+)
+
+// matcher finds runs in a block of data that's being compressed. It
+// keeps a hash chain for every 3 byte sequence in the block, so
+// finding a run only means looking at earlier places that start with
+// the same three bytes.
+type matcher struct {
+	data []byte
+	// Most recent position with each hash, plus one so 0 means
+	// none.
+	head [1 << hashBits]uint16
+	// The position before each position with the same hash, plus
+	// one.
+	prev [blockSize]uint16
+	// Every position before this has been added to the chains.
+	added int
+	// How far back to look for runs.
+	window int
+	// Whether a run may overlap the data it's copied to. If it can't,
+	// every run of the longest length is looked at so the one
+	// furthest back wins, which keeps BestSpeed's output the same as
+	// it's always been. If it can, the search stops at the first run
+	// of maxRun bytes.
+	overlap bool
+}
+
+// reset gets the matcher ready to find runs in data, which must be no
+// more than blockSize bytes.
+func (m *matcher) reset(data []byte, window int, overlap bool) {
+	m.data = data
+	m.head = [1 << hashBits]uint16{}
+	m.added = 0
+	m.window = window
+	m.overlap = overlap
+}
+
+// hash3 returns the hash of the three bytes at the start of b.
+func hash3(b []byte) uint32 {
+	return (uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])) * 2654435761 >> (32 - hashBits)
+}
+
+// findRun looks back in the data before position i to see if we can
+// find a chunk that matches the data from i on. Unless the matcher
+// allows overlap the run must be entirely before i. It returns the
+// length of the longest run and how far back it starts, or -1, -1 if
+// there's no run of at least 3 bytes. If there are several runs of
+// the longest length the one furthest back is used.
+func (m *matcher) findRun(i int) (int, int) {
+	data := m.data
 	// If we don't even have 3 bytes left then we can't have a run.
-	if len(data) < 3 {
+	if len(data)-i < 3 {
 		return -1, -1
 	}
-	idx := -1
-	l := -1
 
-	// we can only look back 1024 bytes, since the offset has to be
-	// encoded in 11 bits.
-	if len(seen) > 1024 {
-		e := len(seen)
-		b := e - 1024
-		seen = seen[b:e]
+	// Add every sequence that ends before i to the chains, or that
+	// starts before i if runs can overlap.
+	end := i
+	if m.overlap {
+		end = i + 2
+	}
+	for ; m.added+3 <= end; m.added++ {
+		h := hash3(data[m.added:])
+		m.prev[m.added] = m.head[h]
+		m.head[h] = uint16(m.added + 1)
+	}
+
+	maxLen := len(data) - i
+	if maxLen > maxRun {
+		maxLen = maxRun
 	}
-	for max := 3; max < 11 && max <= len(data); max++ {
-		offset := bytes.Index(seen, data[0:max])
-		if offset == -1 {
-			break
+	l, dist := -1, -1
+	// Walk back through the chain, newest to oldest, until we fall
+	// out of the window.
+	for p := int(m.head[hash3(data[i:])]) - 1; p >= 0 && p >= i-m.window; p = int(m.prev[p]) - 1 {
+		// The run can't go past i.
+		limit := maxLen
+		if !m.overlap && i-p < limit {
+			limit = i - p
+		}
+		n := 0
+		for n < limit && data[p+n] == data[i+n] {
+			n++
+		}
+		if n >= 3 && n >= l {
+			l, dist = n, i-p
+			if m.overlap && n == maxLen {
+				break
+			}
 		}
-		idx = len(seen) - offset
-		l = max
 	}
 
-	return l, idx
+	return l, dist
+}
+
+// CorruptInputError reports a problem with compressed data passed to
+// Decompress.
+type CorruptInputError struct {
+	// Offset in the compressed data where the problem was found.
+	Offset int
+	// How many bytes had been decompressed when the problem was
+	// found.
+	Output int
+	// What's wrong with the data.
+	Reason string
+}
+
+func (e *CorruptInputError) Error() string {
+	return fmt.Sprintf("lz77: corrupt input at offset %v (output position %v): %v", e.Offset, e.Output, e.Reason)
+}
+
+// corrupt returns a *CorruptInputError for a problem found at offset
+// o of the input, with out bytes decompressed.
+func corrupt(o, out int, reason string) error {
+	return &CorruptInputError{Offset: o, Output: out, Reason: reason}
 }
 
-// Decompress decompresses a compressed block of data.
+// Decompress decompresses a compressed block of data. Malformed input
+// gets a *CorruptInputError.
 func Decompress(data []byte) ([]byte, error) {
 	// Start off assuming that decompressing a buffer makes the result
 	// larger. This is mostly but not always true.
-	ret := make([]byte, 0, len(data)*2)
-	for o := 0; o < len(data); o++ {
-		b := data[o]
-		switch {
-		case b == 0:
-			ret = append(ret, b)
-		case (b >= 1 && b <= 8):
-			if o+int(b)+1 > len(data) {
-				return nil, fmt.Errorf("copy from past end of block: %v/%v", len(data), o+int(b)+1)
-			}
-			d := data[o+1 : o+int(b)+1]
-			ret = append(ret, d...)
-			o += int(b)
-		case (b >= 0x09 && b <= 0x7f):
-			ret = append(ret, b)
-		case b >= 0x80 && b <= 0xbf:
-			o++
-			m := int(b)<<8 + int(data[o])
-			dist := (m & 0x3fff) >> 3
-			l := m&0x07 + 3
-			if dist > len(ret) {
-				log.Fatalf("dist %v, len %v but len(ret) only %v (%x)", dist, l, len(ret), m)
-			}
-			if dist < 1 {
-				log.Printf("dist %v is less than 1", dist)
-				dist = 1
-			}
-			sl := len(ret)
-			for i := 0; i < l; i++ {
-				idx := (len(ret) - dist)
-				if idx < 0 || idx >= len(ret) {
-					log.Printf("Out of range; started %v, off %v, len %v, curidx %v, curlen %v", sl, dist, l, idx, len(ret))
-				}
-				sb := ret[idx]
-				ret = append(ret, sb)
+	return DecompressSize(data, len(data)*2)
+}
+
+// DecompressSize is like Decompress, but takes the size of the
+// decompressed data, such as the record size in a PalmDoc header, so
+// the output can be allocated once up front. The size is only a
+// hint; the output still grows if it's too small.
+func DecompressSize(data []byte, size int) ([]byte, error) {
+	ret, err := AppendDecompress(make([]byte, 0, size), data)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// AppendDecompress decompresses src and appends the result to dst,
+// returning the extended slice. If dst has room for the output
+// nothing is allocated. Back references can't reach into what was in
+// dst before. On error dst is returned as it was passed in.
+func AppendDecompress(dst, src []byte) ([]byte, error) {
+	return appendDecompress(dst, src, false)
+}
+
+// DecompressStrict decompresses a single PalmDoc text record, which
+// is one compressed block. Unlike Decompress it rejects anything a
+// conforming compressor couldn't have written: back references with
+// a distance of 0 and output over 4096 bytes, as well as the broken
+// input Decompress rejects. The *CorruptInputError says where in both
+// the input and the output the problem was found.
+func DecompressStrict(data []byte) ([]byte, error) {
+	ret, err := appendDecompress(make([]byte, 0, blockSize), data, true)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// appendDecompress is AppendDecompress, optionally with
+// DecompressStrict's checks.
+func appendDecompress(dst, src []byte, strict bool) ([]byte, error) {
+	ret := dst
+	for o := 0; o < len(src); {
+		var err error
+		ret, o, err = decodeToken(ret, len(dst), src, o, strict)
+		if err != nil {
+			return dst, err
+		}
+	}
+
+	return ret, nil
+}
+
+// tokenLen returns how many bytes of compressed data make up the
+// token that starts with b.
+func tokenLen(b byte) int {
+	switch {
+	case b >= 1 && b <= 8:
+		return int(b) + 1
+	case b >= 0x80 && b <= 0xbf:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// decodeToken decodes the token at offset o of data and appends the
+// result to ret. Everything in ret from start on is data decoded so
+// far, which back references can reach. It returns the new ret and
+// the offset of the next token. If strict is set it also makes
+// DecompressStrict's checks.
+func decodeToken(ret []byte, start int, data []byte, o int, strict bool) ([]byte, int, error) {
+	out := len(ret) - start
+	tok := o
+	b := data[o]
+	switch {
+	case b == 0:
+		ret = append(ret, b)
+	case (b >= 1 && b <= 8):
+		if o+int(b)+1 > len(data) {
+			return nil, 0, corrupt(o, out, fmt.Sprintf("copy from past end of block: %v/%v", len(data), o+int(b)+1))
+		}
+		d := data[o+1 : o+int(b)+1]
+		ret = append(ret, d...)
+		o += int(b)
+	case (b >= 0x09 && b <= 0x7f):
+		ret = append(ret, b)
+	case b >= 0x80 && b <= 0xbf:
+		if o+1 >= len(data) {
+			return nil, 0, corrupt(o, out, "back reference truncated")
+		}
+		m := int(b)<<8 + int(data[o+1])
+		// The distance is 11 bits, so it can't be more than 2047.
+		dist := (m & 0x3fff) >> 3
+		l := m&0x07 + 3
+		if dist < 1 {
+			if strict {
+				return nil, 0, corrupt(o, out, "back reference distance 0")
 			}
-		case b >= 0xc0:
-			ret = append(ret, ' ')
-			ret = append(ret, b^0x80)
-		default:
-			log.Fatalf("unknown byte %v", b)
+			dist = 1
 		}
+		if dist > out {
+			return nil, 0, corrupt(o, out, fmt.Sprintf("dist %v, len %v but only %v bytes decompressed", dist, l, out))
+		}
+		for i := 0; i < l; i++ {
+			ret = append(ret, ret[len(ret)-dist])
+		}
+		o++
+	case b >= 0xc0:
+		ret = append(ret, ' ')
+		ret = append(ret, b^0x80)
+	}
 
+	if strict && len(ret)-start > blockSize {
+		return nil, 0, corrupt(tok, out, fmt.Sprintf("output longer than %v bytes", blockSize))
 	}
 
-	return ret, nil
+	return ret, o + 1, nil
 }
@@ -2,6 +2,7 @@ package lz77
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"math/rand"
 	"strings"
@@ -154,7 +155,7 @@ func TestRoundTrip(t *testing.T) {
 
 }
 
-func TestByteLiteral(t *testing.T) {
+func TestAppendLiteral(t *testing.T) {
 	tests := []struct {
 		in   byte
 		want []byte
@@ -166,7 +167,7 @@ func TestByteLiteral(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		got := byteLiteral(test.in)
+		got := appendLiteral(nil, test.in)
 		if !bytes.Equal(test.want, got) {
 			t.Errorf("Encoding %v got %v want %v", test.in, got, test.want)
 		}
@@ -219,9 +220,132 @@ func TestFindRun(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		gotLen, gotOffset := findRun([]byte(test.data), []byte(test.seen))
+		gotLen, gotOffset := naiveFindRun([]byte(test.data), []byte(test.seen))
 		if gotLen != test.wantLen || gotOffset != test.wantOffset {
-			t.Errorf("findRun(%v): %q/%q, got %v/%v, want %v/%v", test.name, test.data, test.seen, gotOffset, gotLen, test.wantOffset, test.wantLen)
+			t.Errorf("naiveFindRun(%v): %q/%q, got %v/%v, want %v/%v", test.name, test.data, test.seen, gotOffset, gotLen, test.wantOffset, test.wantLen)
+		}
+
+		m := &matcher{}
+		m.reset([]byte(test.seen+test.data), window, false)
+		gotLen, gotOffset = m.findRun(len(test.seen))
+		if gotLen != test.wantLen || gotOffset != test.wantOffset {
+			t.Errorf("matcher.findRun(%v): %q/%q, got %v/%v, want %v/%v", test.name, test.data, test.seen, gotOffset, gotLen, test.wantOffset, test.wantLen)
+		}
+	}
+}
+
+// naiveFindRun is the original brute force run finder, which the
+// matcher has to agree with. It looks back in the data we've already
+// compressed to see if we can find a chunk that matches the data
+// that's left to be compressed.
+func naiveFindRun(data []byte, seen []byte) (int, int) {
+	// If we don't even have 3 bytes left then we can't have a run.
+	if len(data) < 3 {
+		return -1, -1
+	}
+	idx := -1
+	l := -1
+
+	// we can only look back 1024 bytes, since the offset has to be
+	// encoded in 11 bits.
+	if len(seen) > 1024 {
+		e := len(seen)
+		b := e - 1024
+		seen = seen[b:e]
+	}
+	for max := 3; max < 11 && max <= len(data); max++ {
+		offset := bytes.Index(seen, data[0:max])
+		if offset == -1 {
+			break
+		}
+		idx = len(seen) - offset
+		l = max
+	}
+
+	return l, idx
+}
+
+// TestMatcherAgreesWithNaive checks the matcher finds exactly the
+// same runs as the brute force search at every position of every
+// block of some real files, so the compressed output is unchanged.
+func TestMatcherAgreesWithNaive(t *testing.T) {
+	files := []string{
+		"lz77.go",
+		"testdata/ioutil.html",
+	}
+
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Errorf("error reading %v: %v", file, err)
+			continue
+		}
+		// Throw in some repetitive data too, where the chains get
+		// long.
+		contents = append(contents, bytes.Repeat([]byte("aaaaaaaaaaaaaaab"), 300)...)
+		m := &matcher{}
+		for start := 0; start < len(contents); start += blockSize {
+			end := start + blockSize
+			if end > len(contents) {
+				end = len(contents)
+			}
+			block := contents[start:end]
+			m.reset(block, window, false)
+			for i := range block {
+				wantLen, wantOffset := naiveFindRun(block[i:], block[:i])
+				gotLen, gotOffset := m.findRun(i)
+				if gotLen != wantLen || gotOffset != wantOffset {
+					t.Fatalf("%v: block at %v, position %v: got %v/%v, want %v/%v", file, start, i, gotOffset, gotLen, wantOffset, wantLen)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		b.Fatalf("error reading test data: %v", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Compress(data)
+	}
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		b.Fatalf("error reading test data: %v", err)
+	}
+	c, _ := Compress(data)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Decompress(c)
+	}
+}
+
+// BenchmarkNaiveFindRun runs the old brute force run finder over the
+// test data, for comparison with BenchmarkCompress.
+func BenchmarkNaiveFindRun(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		b.Fatalf("error reading test data: %v", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for start := 0; start < len(data); start += blockSize {
+			end := start + blockSize
+			if end > len(data) {
+				end = len(data)
+			}
+			block := data[start:end]
+			for i := range block {
+				naiveFindRun(block[i:], block[:i])
+			}
 		}
 	}
 }
@@ -247,3 +371,270 @@ func TestFileRoundTrip(t *testing.T) {
 	}
 
 }
+
+func TestDecompressErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		compressed []byte
+		wantOffset int
+	}{
+		{
+			name:       "Literal chunk past end",
+			compressed: []byte{'a', 0x05, 0x01, 0x02},
+			wantOffset: 1,
+		},
+		{
+			name:       "Truncated run",
+			compressed: []byte{'a', 'b', 'c', 0x80},
+			wantOffset: 3,
+		},
+		{
+			name:       "Run before start",
+			compressed: []byte{'a', 'b', 0x80, 0x03<<3 | 0x01},
+			wantOffset: 2,
+		},
+		{
+			name:       "Zero distance at start",
+			compressed: []byte{0x80, 0x00},
+			wantOffset: 0,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := Decompress(test.compressed)
+		var ce *CorruptInputError
+		if !errors.As(err, &ce) {
+			t.Errorf("Decompress(%v): got %v/%v, want a *CorruptInputError", test.name, got, err)
+			continue
+		}
+		if ce.Offset != test.wantOffset {
+			t.Errorf("Decompress(%v): got error at offset %v, want %v", test.name, ce.Offset, test.wantOffset)
+		}
+	}
+}
+
+func FuzzDecompress(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0x40, 0x50, 0x60})
+	f.Add([]byte{0x05, 0x01, 0x02, 0x03, 0x04, 0x05})
+	f.Add([]byte{'a', 'b', 'c', 'd', 0x80, 0x02<<3 | 0x01})
+	f.Add([]byte{0x80})
+	f.Add([]byte{0x08})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, err := Decompress(data)
+		var ce *CorruptInputError
+		if err != nil && !errors.As(err, &ce) {
+			t.Errorf("Decompress(%v) returned %v, not a *CorruptInputError", data, err)
+		}
+		// And it has to agree with the reference decoder.
+		checkDecoders(t, data)
+	})
+}
+
+func TestDecompressStrict(t *testing.T) {
+	tests := []struct {
+		name       string
+		compressed []byte
+		wantOffset int
+		wantOutput int
+	}{
+		{
+			name:       "Zero distance",
+			compressed: []byte{'a', 'b', 0x80, 0x00},
+			wantOffset: 2,
+			wantOutput: 2,
+		},
+		{
+			name:       "Too long",
+			compressed: append(bytes.Repeat([]byte{'a', 0x80, 0x0e}, 409), 'b', 'c', 'd', 'e', 'f', 'g', 'h'),
+			wantOffset: 1233,
+			wantOutput: 4096,
+		},
+		{
+			name:       "Run makes it too long",
+			compressed: append(bytes.Repeat([]byte{'a', 0x80, 0x0e}, 409), 'b', 'c', 'd', 'e', 0x80, 0x08),
+			wantOffset: 1231,
+			wantOutput: 4094,
+		},
+		{
+			name:       "Literal chunk past end",
+			compressed: []byte{'a', 'b', 0x05, 0x01, 0x02},
+			wantOffset: 2,
+			wantOutput: 2,
+		},
+		{
+			name:       "Truncated run",
+			compressed: []byte{'a', 'b', 'c', 0x80},
+			wantOffset: 3,
+			wantOutput: 3,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := DecompressStrict(test.compressed)
+		var ce *CorruptInputError
+		if !errors.As(err, &ce) {
+			t.Errorf("DecompressStrict(%v): got %v bytes/%v, want a *CorruptInputError", test.name, len(got), err)
+			continue
+		}
+		if ce.Offset != test.wantOffset || ce.Output != test.wantOutput {
+			t.Errorf("DecompressStrict(%v): got error at %v/%v, want %v/%v", test.name, ce.Offset, ce.Output, test.wantOffset, test.wantOutput)
+		}
+	}
+
+	// Plain Decompress still takes a distance of 0 as 1.
+	got, err := Decompress([]byte{'a', 0x80, 0x00})
+	if err != nil || string(got) != "aaaa" {
+		t.Errorf("Decompress with distance 0: got %q/%v, want \"aaaa\"", got, err)
+	}
+
+	// Everything we write has to pass, one block at a time.
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	data = append(data, randomJunk(6, 5000)...)
+	for _, level := range levels {
+		for start := 0; start < len(data); start += blockSize {
+			end := start + blockSize
+			if end > len(data) {
+				end = len(data)
+			}
+			c, _ := CompressLevel(data[start:end], level)
+			got, err := DecompressStrict(c)
+			if err != nil {
+				t.Errorf("level %v, block at %v: error: %v", level, start, err)
+				continue
+			}
+			if !bytes.Equal(got, data[start:end]) {
+				t.Errorf("level %v, block at %v: mismatch", level, start)
+			}
+		}
+	}
+}
+
+func TestAppendCompress(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	prefix := []byte("prefix")
+	for _, level := range levels {
+		want, _ := CompressLevel(data, level)
+		got, err := AppendCompressLevel(append([]byte{}, prefix...), data, level)
+		if err != nil {
+			t.Fatalf("level %v: error: %v", level, err)
+		}
+		if !bytes.Equal(got[:len(prefix)], prefix) || !bytes.Equal(got[len(prefix):], want) {
+			t.Errorf("level %v: AppendCompressLevel doesn't match CompressLevel", level)
+		}
+	}
+	if got := AppendCompress(nil, data); !bytes.Equal(got, mustCompress(t, data)) {
+		t.Errorf("AppendCompress doesn't match Compress")
+	}
+	if _, err := AppendCompressLevel(nil, data, 0); err == nil {
+		t.Errorf("AppendCompressLevel with level 0 got no error, want one")
+	}
+}
+
+func mustCompress(t *testing.T, data []byte) []byte {
+	c, err := Compress(data)
+	if err != nil {
+		t.Fatalf("Compress error: %v", err)
+	}
+	return c
+}
+
+func TestAppendDecompress(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	c := mustCompress(t, data)
+	prefix := []byte("prefix")
+
+	got, err := AppendDecompress(append([]byte{}, prefix...), c)
+	if err != nil {
+		t.Fatalf("AppendDecompress error: %v", err)
+	}
+	if !bytes.Equal(got[:len(prefix)], prefix) || !bytes.Equal(got[len(prefix):], data) {
+		t.Errorf("AppendDecompress output mismatch")
+	}
+
+	got, err = DecompressSize(c, len(data))
+	if err != nil {
+		t.Fatalf("DecompressSize error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("DecompressSize output mismatch")
+	}
+
+	// The back reference would reach into the prefix.
+	got, err = AppendDecompress(prefix, []byte{'a', 0x80, 0x18})
+	var ce *CorruptInputError
+	if !errors.As(err, &ce) {
+		t.Fatalf("got error %v, want a *CorruptInputError", err)
+	}
+	if !bytes.Equal(got, prefix) {
+		t.Errorf("got %q on error, want %q", got, prefix)
+	}
+}
+
+// TestAllocs checks the append APIs don't allocate when they're given
+// enough room.
+func TestAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector makes sync.Pool allocate")
+	}
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	c := mustCompress(t, data)
+	buf := make([]byte, 0, 2*len(data))
+
+	for _, level := range levels {
+		// Warm up the pool.
+		AppendCompressLevel(buf, data, level)
+		allocs := testing.AllocsPerRun(10, func() {
+			AppendCompressLevel(buf, data, level)
+		})
+		if allocs != 0 {
+			t.Errorf("AppendCompressLevel at level %v: %v allocations, want 0", level, allocs)
+		}
+	}
+
+	allocs := testing.AllocsPerRun(10, func() {
+		AppendDecompress(buf, c)
+	})
+	if allocs != 0 {
+		t.Errorf("AppendDecompress: %v allocations, want 0", allocs)
+	}
+}
+
+func BenchmarkAppendCompress(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		b.Fatalf("error reading test data: %v", err)
+	}
+	buf := make([]byte, 0, len(data))
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendCompress(buf[:0], data)
+	}
+}
+
+func BenchmarkAppendDecompress(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		b.Fatalf("error reading test data: %v", err)
+	}
+	c, _ := Compress(data)
+	buf := make([]byte, 0, len(data))
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = AppendDecompress(buf[:0], c)
+	}
+}
@@ -0,0 +1,60 @@
+package lz77
+
+import (
+	"runtime"
+	"sync"
+)
+
+// CompressParallel is like CompressLevel, but compresses blocks on up
+// to workers goroutines at once. If workers is 0 or less it uses
+// GOMAXPROCS goroutines. Blocks are compressed independently, so the
+// output is exactly the same as CompressLevel's.
+func CompressParallel(data []byte, level, workers int) ([]byte, error) {
+	if err := checkLevel(level); err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	blocks := (len(data) + blockSize - 1) / blockSize
+	if workers > blocks {
+		workers = blocks
+	}
+	if workers <= 1 {
+		return CompressLevel(data, level)
+	}
+
+	out := make([][]byte, blocks)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range next {
+				start := b * blockSize
+				end := start + blockSize
+				if end > len(data) {
+					end = len(data)
+				}
+				out[b] = compressBlock(make([]byte, 0, end-start), data[start:end], level)
+			}
+		}()
+	}
+	for b := 0; b < blocks; b++ {
+		next <- b
+	}
+	close(next)
+	wg.Wait()
+
+	size := 0
+	for _, c := range out {
+		size += len(c)
+	}
+	ret := make([]byte, 0, size)
+	for _, c := range out {
+		ret = append(ret, c...)
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,52 @@
+package lz77
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCompressParallel(t *testing.T) {
+	html, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	inputs := map[string][]byte{
+		"empty":  {},
+		"short":  []byte("abcabcabc"),
+		"html":   bytes.Repeat(html, 5),
+		"binary": randomJunk(5, 10*blockSize+1),
+	}
+
+	for name, in := range inputs {
+		for _, level := range levels {
+			want, _ := CompressLevel(in, level)
+			for _, workers := range []int{-1, 0, 1, 2, 3, 64} {
+				got, err := CompressParallel(in, level, workers)
+				if err != nil {
+					t.Errorf("%v, level %v, %v workers: error: %v", name, level, workers, err)
+					continue
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("%v, level %v, %v workers: output differs from CompressLevel", name, level, workers)
+				}
+			}
+		}
+	}
+
+	if _, err := CompressParallel(html, 0, 4); err == nil {
+		t.Errorf("CompressParallel with level 0 got no error, want one")
+	}
+}
+
+func BenchmarkCompressParallel(b *testing.B) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		b.Fatalf("error reading test data: %v", err)
+	}
+	data = bytes.Repeat(data, 20)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		CompressParallel(data, BestCompression, 0)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package lz77
+
+// raceEnabled is true when the tests are built with the race
+// detector, which makes sync.Pool drop things at random.
+const raceEnabled = true
@@ -0,0 +1,146 @@
+package lz77
+
+import "fmt"
+
+// TokenKind says what a token in compressed data is.
+type TokenKind int
+
+const (
+	// Literal is a byte passed through as is.
+	Literal TokenKind = iota
+	// SpacePair is a space followed by a byte from 0x40 to 0x7f, sent
+	// as one byte.
+	SpacePair
+	// RawRun is a count from 1 to 8 followed by that many bytes sent
+	// as is.
+	RawRun
+	// BackRef is a two byte reference to earlier output.
+	BackRef
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case Literal:
+		return "literal"
+	case SpacePair:
+		return "space pair"
+	case RawRun:
+		return "raw run"
+	case BackRef:
+		return "back reference"
+	}
+	return fmt.Sprintf("TokenKind(%d)", int(k))
+}
+
+// Token is a single token in compressed data.
+type Token struct {
+	Kind TokenKind
+	// Where the token starts in the compressed data.
+	Offset int
+	// Where the token's output starts in the decompressed data.
+	Output int
+	// For back references, how far back the copy starts, as encoded.
+	// A distance of 0 is taken as 1 when decompressing.
+	Dist int
+	// What the token decompresses to.
+	Data []byte
+}
+
+func (t Token) String() string {
+	switch t.Kind {
+	case BackRef:
+		return fmt.Sprintf("%v->%v: %v dist %v len %v %q", t.Offset, t.Output, t.Kind, t.Dist, len(t.Data), t.Data)
+	case RawRun:
+		return fmt.Sprintf("%v->%v: %v len %v %q", t.Offset, t.Output, t.Kind, len(t.Data), t.Data)
+	}
+	return fmt.Sprintf("%v->%v: %v %q", t.Offset, t.Output, t.Kind, t.Data)
+}
+
+// Disassemble breaks compressed data up into its tokens. If the data
+// is malformed it returns the tokens before the problem along with a
+// *CorruptInputError.
+func Disassemble(data []byte) ([]Token, error) {
+	var tokens []Token
+	var out []byte
+	for o := 0; o < len(data); {
+		b := data[o]
+		start := len(out)
+		next, n, err := decodeToken(out, 0, data, o, false)
+		if err != nil {
+			return tokens, err
+		}
+		out = next
+		t := Token{Offset: o, Output: start, Data: out[start:len(out):len(out)]}
+		switch {
+		case b >= 1 && b <= 8:
+			t.Kind = RawRun
+		case b >= 0x80 && b <= 0xbf:
+			t.Kind = BackRef
+			t.Dist = ((int(b)<<8 + int(data[o+1])) & 0x3fff) >> 3
+		case b >= 0xc0:
+			t.Kind = SpacePair
+		default:
+			t.Kind = Literal
+		}
+		tokens = append(tokens, t)
+		o = n
+	}
+
+	return tokens, nil
+}
+
+// BlockStats describes how a block of data was compressed.
+type BlockStats struct {
+	// How many bytes of input the block holds, and how many they
+	// compressed to.
+	InSize, OutSize int
+	// How many literals and space pairs there are.
+	Literals, SpacePairs int
+	// How many back references there are, and how many of each
+	// length.
+	BackRefs       int
+	BackRefLengths [maxRun + 1]int
+	// How many raw runs there are, and how many bytes are in them.
+	RawRuns, RawBytes int
+}
+
+// CompressWithStats is like CompressLevel, but also returns
+// statistics for each 4096 byte block of the input.
+func CompressWithStats(data []byte, level int) ([]byte, []BlockStats, error) {
+	if err := checkLevel(level); err != nil {
+		return nil, nil, err
+	}
+
+	ret := make([]byte, 0, len(data))
+	var stats []BlockStats
+	for start := 0; start < len(data); start += blockSize {
+		end := start + blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		out := len(ret)
+		ret = compressBlock(ret, data[start:end], level)
+		tokens, err := Disassemble(ret[out:])
+		if err != nil {
+			return nil, nil, err
+		}
+		s := BlockStats{InSize: end - start, OutSize: len(ret) - out}
+		for _, t := range tokens {
+			switch t.Kind {
+			case Literal:
+				s.Literals++
+			case SpacePair:
+				s.SpacePairs++
+			case RawRun:
+				s.RawRuns++
+				s.RawBytes += len(t.Data)
+			case BackRef:
+				s.BackRefs++
+				s.BackRefLengths[len(t.Data)]++
+			}
+		}
+		stats = append(stats, s)
+	}
+
+	return ret, stats, nil
+}
@@ -0,0 +1,102 @@
+package lz77
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	compressed := []byte{'a', 'b', 'c', 0x80, 0x18, 0xe8, 2, 0x01, 0xff, 0x80, 0x00}
+	want := []Token{
+		{Kind: Literal, Offset: 0, Output: 0, Data: []byte("a")},
+		{Kind: Literal, Offset: 1, Output: 1, Data: []byte("b")},
+		{Kind: Literal, Offset: 2, Output: 2, Data: []byte("c")},
+		{Kind: BackRef, Offset: 3, Output: 3, Dist: 3, Data: []byte("abc")},
+		{Kind: SpacePair, Offset: 5, Output: 6, Data: []byte(" h")},
+		{Kind: RawRun, Offset: 6, Output: 8, Data: []byte{0x01, 0xff}},
+		{Kind: BackRef, Offset: 9, Output: 10, Dist: 0, Data: []byte{0xff, 0xff, 0xff}},
+	}
+	got, err := Disassemble(compressed)
+	if err != nil {
+		t.Fatalf("Disassemble error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	wantStrings := []string{
+		`0->0: literal "a"`,
+		`3->3: back reference dist 3 len 3 "abc"`,
+		`5->6: space pair " h"`,
+		`6->8: raw run len 2 "\x01\xff"`,
+	}
+	for i, tok := range []Token{got[0], got[3], got[4], got[5]} {
+		if s := tok.String(); s != wantStrings[i] {
+			t.Errorf("String() got %q, want %q", s, wantStrings[i])
+		}
+	}
+
+	// Broken data gets the tokens before the problem.
+	got, err = Disassemble([]byte{'a', 'b', 0x80})
+	var ce *CorruptInputError
+	if !errors.As(err, &ce) {
+		t.Fatalf("got error %v, want a *CorruptInputError", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("got %v tokens, want 2", len(got))
+	}
+}
+
+func TestCompressWithStats(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	data = append(data, randomJunk(7, 3000)...)
+
+	for _, level := range levels {
+		c, stats, err := CompressWithStats(data, level)
+		if err != nil {
+			t.Fatalf("level %v: error: %v", level, err)
+		}
+		want, _ := CompressLevel(data, level)
+		if !bytes.Equal(c, want) {
+			t.Errorf("level %v: output differs from CompressLevel", level)
+		}
+		if wantBlocks := (len(data) + blockSize - 1) / blockSize; len(stats) != wantBlocks {
+			t.Fatalf("level %v: got %v blocks, want %v", level, len(stats), wantBlocks)
+		}
+
+		in, out := 0, 0
+		for i, s := range stats {
+			in += s.InSize
+			out += s.OutSize
+			// Every input byte has to be accounted for by exactly one
+			// token, and every output byte too.
+			refBytes, refs := 0, 0
+			for l, n := range s.BackRefLengths {
+				refBytes += l * n
+				refs += n
+			}
+			if refs != s.BackRefs {
+				t.Errorf("level %v, block %v: %v back references but the histogram holds %v", level, i, s.BackRefs, refs)
+			}
+			if got := s.Literals + 2*s.SpacePairs + s.RawBytes + refBytes; got != s.InSize {
+				t.Errorf("level %v, block %v: tokens cover %v bytes, want %v", level, i, got, s.InSize)
+			}
+			if got := s.Literals + s.SpacePairs + s.RawRuns + s.RawBytes + 2*s.BackRefs; got != s.OutSize {
+				t.Errorf("level %v, block %v: tokens take %v bytes, want %v", level, i, got, s.OutSize)
+			}
+		}
+		if in != len(data) || out != len(c) {
+			t.Errorf("level %v: blocks total %v/%v, want %v/%v", level, in, out, len(data), len(c))
+		}
+	}
+
+	if _, _, err := CompressWithStats(data, 0); err == nil {
+		t.Errorf("CompressWithStats with level 0 got no error, want one")
+	}
+}
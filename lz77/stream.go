@@ -0,0 +1,179 @@
+package lz77
+
+import (
+	"errors"
+	"io"
+)
+
+// Reader decompresses a stream of lz77 compressed data.
+type Reader struct {
+	r io.Reader
+	// Compressed bytes that have been read but not decoded yet.
+	in []byte
+	// Where in the compressed stream in starts.
+	inOffset int
+	// Where in the decompressed stream out starts.
+	outOffset int
+	// The last maxDist bytes of output already returned, for back
+	// references to follow, then the decoded output that hasn't been
+	// returned yet.
+	out []byte
+	// The next byte of out to return.
+	pos int
+	// Whether r has run out.
+	eof bool
+	// The error to return once out is used up.
+	err error
+}
+
+// NewReader returns a Reader that decompresses the data read from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		r:   r,
+		in:  make([]byte, 0, blockSize),
+		out: make([]byte, 0, maxDist+2*blockSize),
+	}
+}
+
+// Read reads decompressed data into p. Malformed input gets a
+// *CorruptInputError whose offsets count from the start of the
+// stream.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.pos == len(z.out) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.fill()
+	}
+	n := copy(p, z.out[z.pos:])
+	z.pos += n
+	return n, nil
+}
+
+// fill reads more compressed data and decodes every whole token in
+// it.
+func (z *Reader) fill() {
+	// Everything in out has been returned, so only keep what back
+	// references can reach.
+	if len(z.out) > maxDist {
+		z.outOffset += len(z.out) - maxDist
+		n := copy(z.out, z.out[len(z.out)-maxDist:])
+		z.out = z.out[:n]
+		z.pos = n
+	}
+
+	var readErr error
+	if !z.eof {
+		n, err := z.r.Read(z.in[len(z.in):cap(z.in)])
+		z.in = z.in[:len(z.in)+n]
+		if err == io.EOF {
+			z.eof = true
+		} else if err != nil {
+			readErr = err
+		}
+	}
+
+	// A token cut off by the end of the buffer waits for the rest of
+	// it, unless there isn't any more. Then it's decoded anyway to
+	// get the right error.
+	o := 0
+	for o < len(z.in) {
+		if !z.eof && o+tokenLen(z.in[o]) > len(z.in) {
+			break
+		}
+		out, next, err := decodeToken(z.out, 0, z.in, o, false)
+		if err != nil {
+			var ce *CorruptInputError
+			if errors.As(err, &ce) {
+				ce.Offset += z.inOffset
+				ce.Output += z.outOffset
+			}
+			z.err = err
+			return
+		}
+		z.out, o = out, next
+	}
+	z.inOffset += o
+	z.in = z.in[:copy(z.in, z.in[o:])]
+
+	switch {
+	case readErr != nil:
+		z.err = readErr
+	case z.eof && len(z.in) == 0:
+		z.err = io.EOF
+	}
+}
+
+// Writer compresses the data written to it. Data is compressed in
+// 4096 byte blocks just like Compress does, so the output is the same
+// as compressing all the data at once.
+type Writer struct {
+	w     io.Writer
+	level int
+	// Data waiting for a full block.
+	buf []byte
+	// The last block compressed, kept so its space can be reused.
+	cbuf   []byte
+	err    error
+	closed bool
+}
+
+// NewWriter returns a Writer that compresses data written to it at
+// BestSpeed and writes it to w.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, BestSpeed)
+	return z
+}
+
+// NewWriterLevel is like NewWriter, but compresses at the given
+// level, which is one of the levels CompressLevel takes.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if err := checkLevel(level); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, level: level, buf: make([]byte, 0, blockSize)}, nil
+}
+
+// Write compresses p, writing out each block as it fills up.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errors.New("lz77: write to closed Writer")
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(z.buf[len(z.buf):blockSize], p)
+		z.buf = z.buf[:len(z.buf)+n]
+		p = p[n:]
+		written += n
+		if len(z.buf) == blockSize {
+			if err := z.writeBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// writeBlock compresses and writes out the buffered data.
+func (z *Writer) writeBlock() error {
+	z.cbuf = compressBlock(z.cbuf[:0], z.buf, z.level)
+	_, z.err = z.w.Write(z.cbuf)
+	z.buf = z.buf[:0]
+	return z.err
+}
+
+// Close compresses and writes out any data still waiting for a full
+// block. It doesn't close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil || len(z.buf) == 0 {
+		return z.err
+	}
+	return z.writeBlock()
+}
@@ -0,0 +1,181 @@
+package lz77
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func streamInputs(t *testing.T) map[string][]byte {
+	html, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	return map[string][]byte{
+		"empty":      {},
+		"short":      []byte("hello hello hello"),
+		"html":       html,
+		"binary":     randomJunk(3, 3*blockSize+17),
+		"repetitive": bytes.Repeat([]byte("abcabcabcabcx"), 2000),
+	}
+}
+
+func TestWriter(t *testing.T) {
+	for name, in := range streamInputs(t) {
+		for _, level := range levels {
+			want, _ := CompressLevel(in, level)
+
+			// Write in odd sized pieces so block boundaries fall in
+			// the middle of writes.
+			var out bytes.Buffer
+			w, err := NewWriterLevel(&out, level)
+			if err != nil {
+				t.Fatalf("NewWriterLevel(%v) error: %v", level, err)
+			}
+			for rest := in; len(rest) > 0; {
+				n := 1000
+				if n > len(rest) {
+					n = len(rest)
+				}
+				if _, err := w.Write(rest[:n]); err != nil {
+					t.Fatalf("%v, level %v: Write error: %v", name, level, err)
+				}
+				rest = rest[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("%v, level %v: Close error: %v", name, level, err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("%v, level %v: Writer output differs from CompressLevel", name, level)
+			}
+		}
+	}
+}
+
+func TestWriterClosed(t *testing.T) {
+	w := NewWriter(ioutil.Discard)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if _, err := w.Write([]byte("abc")); err == nil {
+		t.Errorf("Write after Close got no error, want one")
+	}
+	if _, err := NewWriterLevel(ioutil.Discard, 0); err == nil {
+		t.Errorf("NewWriterLevel(0) got no error, want one")
+	}
+}
+
+func TestReader(t *testing.T) {
+	for name, want := range streamInputs(t) {
+		c, _ := CompressLevel(want, BestCompression)
+		readers := map[string]io.Reader{
+			"whole":    bytes.NewReader(c),
+			"one byte": iotest.OneByteReader(bytes.NewReader(c)),
+			"half":     iotest.HalfReader(bytes.NewReader(c)),
+		}
+		for rname, r := range readers {
+			got, err := ioutil.ReadAll(NewReader(r))
+			if err != nil {
+				t.Errorf("%v, %v: error: %v", name, rname, err)
+				continue
+			}
+			if eq, fd := findDiff(got, want); !eq {
+				t.Errorf("%v, %v: mismatch at %v", name, rname, fd)
+			}
+		}
+	}
+}
+
+func TestReaderPipe(t *testing.T) {
+	want := streamInputs(t)["html"]
+	pr, pw := io.Pipe()
+	go func() {
+		w, _ := NewWriterLevel(pw, LazyMatching)
+		w.Write(want)
+		w.Close()
+		pw.Close()
+	}()
+	got, err := ioutil.ReadAll(NewReader(pr))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("piped data doesn't match")
+	}
+}
+
+// TestReaderHistory checks back references can reach the full 2047
+// bytes back, even after the Reader has thrown away older output.
+func TestReaderHistory(t *testing.T) {
+	c := randomJunk(4, 5000)
+	for i := range c {
+		c[i] = c[i]&0x3f + 0x40
+	}
+	// 10 bytes from 2047 back.
+	c = append(c, 0xbf, 0xff)
+	want, err := Decompress(c)
+	if err != nil {
+		t.Fatalf("Decompress error: %v", err)
+	}
+	got, err := ioutil.ReadAll(NewReader(iotest.HalfReader(bytes.NewReader(c))))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if eq, fd := findDiff(got, want); !eq {
+		t.Errorf("mismatch at %v", fd)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		compressed []byte
+		wantOffset int
+		wantOutput int
+	}{
+		{
+			name:       "Truncated copy",
+			compressed: []byte{'a', 'b', 'c', 4, 'x'},
+			wantOffset: 3,
+			wantOutput: 3,
+		},
+		{
+			name:       "Truncated back reference",
+			compressed: []byte{'a', 'b', 'c', 0x80},
+			wantOffset: 3,
+			wantOutput: 3,
+		},
+		{
+			name:       "Back reference past history",
+			compressed: []byte{'a', 'b', 0x80, 0x20},
+			wantOffset: 2,
+			wantOutput: 2,
+		},
+		{
+			name:       "Truncated copy after a while",
+			compressed: append(bytes.Repeat([]byte{'a', 0x80, 0x0f}, 500), 5, 'x'),
+			wantOffset: 1500,
+			wantOutput: 5500,
+		},
+	}
+
+	for _, test := range tests {
+		_, err := ioutil.ReadAll(NewReader(iotest.OneByteReader(bytes.NewReader(test.compressed))))
+		var ce *CorruptInputError
+		if !errors.As(err, &ce) {
+			t.Errorf("%v: got error %v, want a *CorruptInputError", test.name, err)
+			continue
+		}
+		if ce.Offset != test.wantOffset || ce.Output != test.wantOutput {
+			t.Errorf("%v: got error at %v/%v, want %v/%v", test.name, ce.Offset, ce.Output, test.wantOffset, test.wantOutput)
+		}
+	}
+
+	r := NewReader(iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := r.Read(make([]byte, 10)); err != io.ErrUnexpectedEOF {
+		t.Errorf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
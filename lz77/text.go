@@ -0,0 +1,199 @@
+package lz77
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Text gives random access to text stored as a series of lz77
+// compressed records, the way PalmDoc books store it. Only the
+// records that hold the text asked for are decompressed, and the most
+// recently used ones are cached. It's safe to use from multiple
+// goroutines at once, as long as the records aren't changed.
+type Text struct {
+	record func(i int) ([]byte, error)
+	// Where each record's text starts, with the total size at the
+	// end.
+	starts []int64
+
+	mu sync.Mutex
+	// Maximum number of decompressed records to keep.
+	cacheSize int
+	// Decompressed records, keyed by record index, with the most
+	// recently used at the front of lru.
+	cache map[int]*list.Element
+	lru   *list.List
+}
+
+// textEntry is a single decompressed record in the Text cache.
+type textEntry struct {
+	index int
+	data  []byte
+}
+
+// defaultTextCache is how many decompressed records a Text keeps
+// unless told otherwise.
+const defaultTextCache = 4
+
+// NewText returns a Text for count records. record is called with an
+// index from 0 to count-1 and returns that record's compressed text.
+// If a format keeps anything else in its text records, record should
+// strip it off. pdb.File's RecordData can be used to read the records
+// on demand, with the index offset to the first text record, and
+// palmdoc.NewText makes a Text from a range of a pdb.Pdb's records.
+//
+// Every record is scanned to find out how much text it holds, but
+// none are decompressed until their text is asked for.
+func NewText(count int, record func(i int) ([]byte, error)) (*Text, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("lz77: negative record count %v", count)
+	}
+	t := &Text{
+		record:    record,
+		starts:    make([]int64, count+1),
+		cacheSize: defaultTextCache,
+		cache:     make(map[int]*list.Element),
+		lru:       list.New(),
+	}
+	for i := 0; i < count; i++ {
+		d, err := record(i)
+		if err != nil {
+			return nil, fmt.Errorf("lz77: record %v: %w", i, err)
+		}
+		n, err := decodedLen(d)
+		if err != nil {
+			return nil, fmt.Errorf("lz77: record %v: %w", i, err)
+		}
+		t.starts[i+1] = t.starts[i] + int64(n)
+	}
+	return t, nil
+}
+
+// decodedLen returns how many bytes data decompresses to, without
+// decompressing it.
+func decodedLen(data []byte) (int, error) {
+	n := 0
+	for o := 0; o < len(data); o += tokenLen(data[o]) {
+		b := data[o]
+		if o+tokenLen(b) > len(data) {
+			return 0, corrupt(o, n, "token runs past end of data")
+		}
+		switch {
+		case b >= 1 && b <= 8:
+			n += int(b)
+		case b >= 0x80 && b <= 0xbf:
+			n += int(data[o+1])&0x07 + 3
+		case b >= 0xc0:
+			n += 2
+		default:
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Size returns the length of the decompressed text.
+func (t *Text) Size() int64 {
+	return t.starts[len(t.starts)-1]
+}
+
+// Locate returns the index of the record that holds byte off of the
+// text, and where in that record's decompressed text the byte is.
+func (t *Text) Locate(off int64) (int, int, error) {
+	if off < 0 || off >= t.Size() {
+		return 0, 0, fmt.Errorf("lz77: offset %v out of range; text is %v bytes", off, t.Size())
+	}
+	// The first record that ends after off. Empty records end where
+	// they start, so they're never picked.
+	i := sort.Search(len(t.starts)-1, func(i int) bool { return t.starts[i+1] > off })
+	return i, int(off - t.starts[i]), nil
+}
+
+// SetCacheSize sets the number of decompressed records to keep in
+// memory. The least recently used records are dropped first. The
+// default is 4; 0 turns caching off.
+func (t *Text) SetCacheSize(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cacheSize = n
+	t.trimCache()
+}
+
+// trimCache drops records from the cache until it's no bigger than
+// the cache size. t.mu must be held.
+func (t *Text) trimCache() {
+	for t.lru.Len() > t.cacheSize {
+		e := t.lru.Back()
+		t.lru.Remove(e)
+		delete(t.cache, e.Value.(*textEntry).index)
+	}
+}
+
+// Record returns the decompressed text of record i. The returned
+// slice may be shared with the cache and other callers, so it must
+// not be modified.
+func (t *Text) Record(i int) ([]byte, error) {
+	if i < 0 || i >= len(t.starts)-1 {
+		return nil, fmt.Errorf("lz77: record %v isn't part of the text", i)
+	}
+
+	t.mu.Lock()
+	if e, ok := t.cache[i]; ok {
+		t.lru.MoveToFront(e)
+		t.mu.Unlock()
+		return e.Value.(*textEntry).data, nil
+	}
+	t.mu.Unlock()
+
+	c, err := t.record(i)
+	if err != nil {
+		return nil, fmt.Errorf("lz77: record %v: %w", i, err)
+	}
+	d, err := DecompressSize(c, int(t.starts[i+1]-t.starts[i]))
+	if err != nil {
+		return nil, fmt.Errorf("lz77: record %v: %w", i, err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cacheSize > 0 {
+		// Someone else may have decompressed the record while we
+		// weren't holding the lock.
+		if e, ok := t.cache[i]; ok {
+			t.lru.MoveToFront(e)
+			return e.Value.(*textEntry).data, nil
+		}
+		t.cache[i] = t.lru.PushFront(&textEntry{index: i, data: d})
+		t.trimCache()
+	}
+	return d, nil
+}
+
+// ReadAt reads len(b) bytes of text starting at off, decompressing
+// only the records that hold them. It implements io.ReaderAt.
+func (t *Text) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("lz77: negative offset %v", off)
+	}
+	n := 0
+	for n < len(b) {
+		if off >= t.Size() {
+			return n, io.EOF
+		}
+		rec, local, err := t.Locate(off)
+		if err != nil {
+			return n, err
+		}
+		d, err := t.Record(rec)
+		if err != nil {
+			return n, err
+		}
+		c := copy(b[n:], d[local:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
@@ -0,0 +1,159 @@
+package lz77
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// textRecords returns data compressed into records of 4096 bytes,
+// each followed by extra.
+func textRecords(t *testing.T, data, extra []byte) [][]byte {
+	var recs [][]byte
+	for start := 0; start < len(data); start += blockSize {
+		end := start + blockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		c := mustCompress(t, data[start:end])
+		recs = append(recs, append(c, extra...))
+	}
+	return recs
+}
+
+// recordFunc returns a function that returns recs[i].
+func recordFunc(recs [][]byte) func(int) ([]byte, error) {
+	return func(i int) ([]byte, error) { return recs[i], nil }
+}
+
+func TestText(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	recs := textRecords(t, data, nil)
+
+	text, err := NewText(len(recs), recordFunc(recs))
+	if err != nil {
+		t.Fatalf("NewText error: %v", err)
+	}
+	if text.Size() != int64(len(data)) {
+		t.Errorf("Size() got %v, want %v", text.Size(), len(data))
+	}
+
+	for _, off := range []int64{0, 1, 4095, 4096, 4097, int64(len(data)) - 1} {
+		rec, local, err := text.Locate(off)
+		if err != nil {
+			t.Errorf("Locate(%v) error: %v", off, err)
+			continue
+		}
+		if wantRec, wantLocal := int(off/blockSize), int(off%blockSize); rec != wantRec || local != wantLocal {
+			t.Errorf("Locate(%v) got %v/%v, want %v/%v", off, rec, local, wantRec, wantLocal)
+		}
+	}
+	for _, off := range []int64{-1, int64(len(data))} {
+		if _, _, err := text.Locate(off); err == nil {
+			t.Errorf("Locate(%v) got no error, want one", off)
+		}
+	}
+
+	// Read across a record boundary.
+	b := make([]byte, 100)
+	n, err := text.ReadAt(b, 4050)
+	if err != nil || n != len(b) {
+		t.Fatalf("ReadAt got %v/%v, want %v/nil", n, err, len(b))
+	}
+	if !bytes.Equal(b, data[4050:4150]) {
+		t.Errorf("ReadAt got %q, want %q", b, data[4050:4150])
+	}
+	if l := text.lru.Len(); l != 2 {
+		t.Errorf("got %v records cached, want 2", l)
+	}
+
+	// Read off the end.
+	n, err = text.ReadAt(b, int64(len(data))-10)
+	if err != io.EOF || n != 10 {
+		t.Errorf("ReadAt at end got %v/%v, want 10/EOF", n, err)
+	}
+
+	// The whole thing through an io.SectionReader.
+	got, err := ioutil.ReadAll(io.NewSectionReader(text, 0, text.Size()))
+	if err != nil {
+		t.Fatalf("ReadAll error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("text doesn't match")
+	}
+	if l := text.lru.Len(); l != defaultTextCache {
+		t.Errorf("got %v records cached, want %v", l, defaultTextCache)
+	}
+	text.SetCacheSize(1)
+	if l := text.lru.Len(); l != 1 {
+		t.Errorf("got %v records cached after SetCacheSize(1), want 1", l)
+	}
+
+	for _, i := range []int{-1, len(recs)} {
+		if _, err := text.Record(i); err == nil {
+			t.Errorf("Record(%v) got no error, want one", i)
+		}
+	}
+	if _, err := NewText(-1, recordFunc(recs)); err == nil {
+		t.Errorf("NewText with a negative count got no error, want one")
+	}
+}
+
+func TestTextTrim(t *testing.T) {
+	data := bytes.Repeat([]byte("All work and no play makes Jack a dull boy. "), 300)
+	recs := textRecords(t, data, []byte{0xde, 0xad, 3})
+	// Records with no text after trimming should be skipped
+	// over.
+	recs = append(recs[:1], append([][]byte{{1}}, recs[1:]...)...)
+	trim := func(i int) ([]byte, error) {
+		d := recs[i]
+		return d[:len(d)-int(d[len(d)-1])], nil
+	}
+
+	text, err := NewText(len(recs), trim)
+	if err != nil {
+		t.Fatalf("NewText error: %v", err)
+	}
+	got := make([]byte, text.Size())
+	if _, err := text.ReadAt(got, 0); err != nil {
+		t.Fatalf("ReadAt error: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("text doesn't match")
+	}
+	if rec, _, _ := text.Locate(blockSize); rec != 2 {
+		t.Errorf("Locate(%v) got record %v, want 2", blockSize, rec)
+	}
+}
+
+func TestTextCorrupt(t *testing.T) {
+	var ce *CorruptInputError
+	if _, err := NewText(1, recordFunc([][]byte{{'a', 0x80}})); !errors.As(err, &ce) {
+		t.Errorf("NewText with truncated record got %v, want a *CorruptInputError", err)
+	}
+
+	text, err := NewText(1, recordFunc([][]byte{{'a', 0x80, 0x20}}))
+	if err != nil {
+		t.Fatalf("NewText error: %v", err)
+	}
+	if _, err := text.ReadAt(make([]byte, 1), 0); !errors.As(err, &ce) {
+		t.Errorf("ReadAt with bad back reference got %v, want a *CorruptInputError", err)
+	}
+
+	// Errors reading the records are passed on.
+	readErr := errors.New("can't read")
+	fail := func(int) ([]byte, error) { return nil, readErr }
+	if _, err := NewText(1, fail); !errors.Is(err, readErr) {
+		t.Errorf("NewText with a failing reader got %v, want %v", err, readErr)
+	}
+	text.record = fail
+	text.SetCacheSize(0)
+	if _, err := text.Record(0); !errors.Is(err, readErr) {
+		t.Errorf("Record with a failing reader got %v, want %v", err, readErr)
+	}
+}
@@ -0,0 +1,78 @@
+package mobi
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// cp1252 maps bytes 0x80-0x9f of Windows code page 1252 to the runes
+// they stand for. The rest of the code page is the same as Latin-1.
+// The five bytes the code page leaves undefined map to the matching
+// C1 control characters, as Windows does.
+var cp1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// decodeCP1252 converts CP1252 text to UTF-8.
+func decodeCP1252(b []byte) []byte {
+	ret := make([]byte, 0, len(b))
+	for _, c := range b {
+		switch {
+		case c < 0x80:
+			ret = append(ret, c)
+		case c < 0xa0:
+			ret = utf8.AppendRune(ret, cp1252[c-0x80])
+		default:
+			ret = utf8.AppendRune(ret, rune(c))
+		}
+	}
+	return ret
+}
+
+// encodeCP1252 converts UTF-8 text to CP1252.
+func encodeCP1252(s string) ([]byte, error) {
+	ret := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80 || (r >= 0xa0 && r <= 0xff):
+			ret = append(ret, byte(r))
+		default:
+			c, ok := cp1252Byte(r)
+			if !ok {
+				return nil, fmt.Errorf("mobi: %q can't be written in CP1252", r)
+			}
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
+
+// cp1252Byte returns the byte from 0x80 to 0x9f that stands for r in
+// CP1252, if there is one.
+func cp1252Byte(r rune) (byte, bool) {
+	for i, c := range cp1252 {
+		if c == r {
+			return byte(0x80 + i), true
+		}
+	}
+	return 0, false
+}
+
+// decodeText converts text in the given encoding to a string.
+func decodeText(encoding uint32, b []byte) string {
+	if encoding == CP1252 {
+		return string(decodeCP1252(b))
+	}
+	return string(b)
+}
+
+// encodeText converts s to the given encoding.
+func encodeText(encoding uint32, s string) ([]byte, error) {
+	if encoding == CP1252 {
+		return encodeCP1252(s)
+	}
+	return []byte(s), nil
+}
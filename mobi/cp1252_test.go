@@ -0,0 +1,19 @@
+package mobi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCP1252(t *testing.T) {
+	in := []byte{'a', 0x80, 0x93, 0x94, 0xe9, 0x81}
+	want := "a€“”é\u0081"
+	got := decodeCP1252(in)
+	if string(got) != want {
+		t.Errorf("decodeCP1252 got %q, want %q", got, want)
+	}
+	back, err := encodeCP1252(want)
+	if err != nil || !bytes.Equal(back, in) {
+		t.Errorf("encodeCP1252 got %v/%v, want %v", back, err, in)
+	}
+}
@@ -0,0 +1,184 @@
+package mobi
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EXTHType says what an EXTH record holds.
+type EXTHType uint32
+
+// EXTH record types. The ones marked as numbers hold a 4 byte big
+// endian number; the rest hold text in the book's encoding.
+const (
+	EXTHAuthor         EXTHType = 100
+	EXTHPublisher      EXTHType = 101
+	EXTHImprint        EXTHType = 102
+	EXTHDescription    EXTHType = 103
+	EXTHISBN           EXTHType = 104
+	EXTHSubject        EXTHType = 105
+	EXTHPublishingDate EXTHType = 106
+	EXTHReview         EXTHType = 107
+	EXTHContributor    EXTHType = 108
+	EXTHRights         EXTHType = 109
+	EXTHSubjectCode    EXTHType = 110
+	EXTHBookType       EXTHType = 111
+	EXTHSource         EXTHType = 112
+	EXTHASIN           EXTHType = 113
+	EXTHVersion        EXTHType = 114
+	// A number.
+	EXTHStartReading EXTHType = 116
+	// A number: the cover image, counting from the first image
+	// record.
+	EXTHCoverOffset EXTHType = 201
+	// A number: the thumbnail image, counting from the first image
+	// record.
+	EXTHThumbOffset  EXTHType = 202
+	EXTHUpdatedTitle EXTHType = 503
+	EXTHLanguage     EXTHType = 524
+)
+
+// EXTHRecord is a single EXTH metadata record.
+type EXTHRecord struct {
+	Type EXTHType
+	Data []byte
+}
+
+// exthHeaderSize is the size of the EXTH block header: the
+// identifier, the block length and the record count.
+const exthHeaderSize = 12
+
+// unmarshalEXTH decodes the EXTH block that starts at offset o of b.
+func (h *Header) unmarshalEXTH(b []byte, o int) error {
+	start := o
+	if o+exthHeaderSize > len(b) || string(b[o:o+4]) != "EXTH" {
+		return fmt.Errorf("mobi: header flags say there's an EXTH block but there isn't one")
+	}
+	length := int(binary.BigEndian.Uint32(b[o+4:]))
+	count := int(binary.BigEndian.Uint32(b[o+8:]))
+	o += exthHeaderSize
+	for i := 0; i < count; i++ {
+		if o+8 > len(b) {
+			return fmt.Errorf("mobi: EXTH record %v is past the end of the record", i)
+		}
+		t := binary.BigEndian.Uint32(b[o:])
+		l := int(binary.BigEndian.Uint32(b[o+4:]))
+		if l < 8 || o+l > len(b) {
+			return fmt.Errorf("mobi: EXTH record %v has bad length %v", i, l)
+		}
+		h.EXTH = append(h.EXTH, EXTHRecord{Type: EXTHType(t), Data: append([]byte(nil), b[o+8:o+l]...)})
+		o += l
+	}
+	size := o - start
+	h.exthLenPadded = length > size
+	h.exthEnd = start + size + exthPadding(size)
+	return nil
+}
+
+// exthPadding returns how many bytes of padding follow an EXTH block
+// of size bytes to bring it to a multiple of 4.
+func exthPadding(size int) int {
+	return (4 - size%4) % 4
+}
+
+// appendEXTH appends the encoded EXTH block to b.
+func (h *Header) appendEXTH(b []byte) []byte {
+	size := exthHeaderSize
+	for _, r := range h.EXTH {
+		size += 8 + len(r.Data)
+	}
+	pad := exthPadding(size)
+	length := size
+	if h.exthLenPadded {
+		length += pad
+	}
+
+	b = append(b, "EXTH"...)
+	b = binary.BigEndian.AppendUint32(b, uint32(length))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(h.EXTH)))
+	for _, r := range h.EXTH {
+		b = binary.BigEndian.AppendUint32(b, uint32(r.Type))
+		b = binary.BigEndian.AppendUint32(b, uint32(8+len(r.Data)))
+		b = append(b, r.Data...)
+	}
+	return append(b, make([]byte, pad)...)
+}
+
+// EXTHStrings returns the text of every EXTH record of type t, such
+// as all of a book's authors.
+func (h *Header) EXTHStrings(t EXTHType) []string {
+	var ret []string
+	for _, r := range h.EXTH {
+		if r.Type == t {
+			ret = append(ret, decodeText(h.Encoding, r.Data))
+		}
+	}
+	return ret
+}
+
+// EXTHString returns the text of the first EXTH record of type t, and
+// whether there is one.
+func (h *Header) EXTHString(t EXTHType) (string, bool) {
+	for _, r := range h.EXTH {
+		if r.Type == t {
+			return decodeText(h.Encoding, r.Data), true
+		}
+	}
+	return "", false
+}
+
+// EXTHUint32 returns the number held in the first EXTH record of type
+// t, and whether there is one.
+func (h *Header) EXTHUint32(t EXTHType) (uint32, bool) {
+	for _, r := range h.EXTH {
+		if r.Type == t && len(r.Data) == 4 {
+			return binary.BigEndian.Uint32(r.Data), true
+		}
+	}
+	return 0, false
+}
+
+// SetEXTHString makes s, in the book's encoding, the only EXTH record
+// of type t. If there already was one it's replaced where it is.
+func (h *Header) SetEXTHString(t EXTHType, s string) error {
+	d, err := encodeText(h.Encoding, s)
+	if err != nil {
+		return err
+	}
+	h.setEXTH(t, d)
+	return nil
+}
+
+// SetEXTHUint32 makes v the only EXTH record of type t. If there
+// already was one it's replaced where it is.
+func (h *Header) SetEXTHUint32(t EXTHType, v uint32) {
+	h.setEXTH(t, binary.BigEndian.AppendUint32(nil, v))
+}
+
+// setEXTH makes d the only EXTH record of type t.
+func (h *Header) setEXTH(t EXTHType, d []byte) {
+	for i, r := range h.EXTH {
+		if r.Type == t {
+			h.EXTH[i].Data = d
+			h.deleteEXTH(t, i+1)
+			return
+		}
+	}
+	h.EXTH = append(h.EXTH, EXTHRecord{Type: t, Data: d})
+}
+
+// DeleteEXTH removes every EXTH record of type t.
+func (h *Header) DeleteEXTH(t EXTHType) {
+	h.deleteEXTH(t, 0)
+}
+
+// deleteEXTH removes the EXTH records of type t from h.EXTH[from] on.
+func (h *Header) deleteEXTH(t EXTHType, from int) {
+	kept := h.EXTH[:from]
+	for _, r := range h.EXTH[from:] {
+		if r.Type != t {
+			kept = append(kept, r)
+		}
+	}
+	h.EXTH = kept
+}
@@ -0,0 +1,301 @@
+// Package mobi reads and writes the MOBI header and EXTH metadata
+// that MOBI books keep in record 0 of their PDB, and extracts their
+// text.
+//
+// Record 0 of a MOBI book starts with a PalmDoc header, followed by
+// the MOBI header, an optional EXTH block of metadata records, and
+// the book's full name.
+package mobi
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/writingtoole/pdb"
+	"github.com/writingtoole/pdb/palmdoc"
+)
+
+// Text encodings.
+const (
+	CP1252 = 1252
+	UTF8   = 65001
+)
+
+// Book types.
+const (
+	TypeBook     = 2
+	TypePalmDoc  = 3
+	TypeAudio    = 4
+	TypeNews     = 257
+	TypeFeed     = 258
+	TypeMagazine = 259
+)
+
+// FlagEXTH is set in Header.Flags when there's an EXTH block.
+// MarshalBinary sets or clears it to match Header.EXTH.
+const FlagEXTH = 0x40
+
+// Offsets of the MOBI header fields from the start of record 0.
+const (
+	offIdentifier     = 16
+	offHeaderLength   = 20
+	offType           = 24
+	offEncoding       = 28
+	offUniqueID       = 32
+	offVersion        = 36
+	offFirstNonBook   = 80
+	offFullNameOffset = 84
+	offFullNameLength = 88
+	offLocale         = 92
+	offInputLanguage  = 96
+	offOutputLanguage = 100
+	offMinVersion     = 104
+	offFirstImage     = 108
+	offFlags          = 128
+	offFirstContent   = 192
+	offLastContent    = 194
+	offExtraDataFlags = 242
+)
+
+// palmDocSize is the size of the PalmDoc header before the MOBI
+// header.
+const palmDocSize = 16
+
+// extraDataVersion is the first MOBI version with the extra data
+// flags.
+const extraDataVersion = 5
+
+// defaultHeaderLength is the MOBI header length used for headers that
+// weren't read from a file.
+const defaultHeaderLength = 232
+
+// Header is the contents of record 0 of a MOBI book. Fields the MOBI
+// header is too short to hold are left 0. When a header that was read
+// in is written back out, any bytes this type doesn't know about are
+// kept as they were.
+type Header struct {
+	// The PalmDoc header at the start of the record. Compression and
+	// RecordCount say how the text is stored.
+	PalmDoc palmdoc.Header
+	// What kind of book this is, such as TypeBook.
+	Type uint32
+	// Text encoding, CP1252 or UTF8.
+	Encoding uint32
+	// Unique ID of the book.
+	UniqueID uint32
+	// MOBI format version.
+	Version uint32
+	// The first record that isn't text.
+	FirstNonBookRecord uint32
+	// The book's full name.
+	FullName string
+	// Book language, as a Windows locale ID.
+	Locale uint32
+	// Dictionary input and output languages.
+	InputLanguage, OutputLanguage uint32
+	// Oldest reader version that can read the book.
+	MinVersion uint32
+	// The first image record.
+	FirstImageRecord uint32
+	// Header flags, such as FlagEXTH.
+	Flags uint32
+	// First and last content records.
+	FirstContentRecord, LastContentRecord uint16
+	// Says what trailing entries there are at the end of each text
+	// record. Only used from MOBI version 5 on; it's 0 for older
+	// books.
+	ExtraDataFlags uint16
+	// EXTH metadata records, in file order.
+	EXTH []EXTHRecord
+
+	// The record as it was read, if it was.
+	raw []byte
+	// Length of the MOBI header.
+	headerLength uint32
+	// Whether the EXTH length read in counted the padding after the
+	// records. Writers disagree about that.
+	exthLenPadded bool
+	// Where the EXTH block and its padding ended, and where the full
+	// name was, in raw.
+	exthEnd, nameOffset, nameLength int
+}
+
+// Encryption returns the encryption type from the PalmDoc header. 0
+// means the book isn't encrypted.
+func (h *Header) Encryption() uint16 {
+	return uint16(h.PalmDoc.CurrentPosition >> 16)
+}
+
+// ReadHeader decodes record 0 of the MOBI book p.
+func ReadHeader(p *pdb.Pdb) (*Header, error) {
+	if len(p.Records) == 0 {
+		return nil, fmt.Errorf("mobi: no header record")
+	}
+	h := &Header{}
+	if err := h.UnmarshalBinary(p.Records[0].Data); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// WriteHeader encodes h and stores it as record 0 of p.
+func WriteHeader(p *pdb.Pdb, h *Header) error {
+	if len(p.Records) == 0 {
+		return fmt.Errorf("mobi: no header record")
+	}
+	b, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	p.Records[0].Data = b
+	return nil
+}
+
+// UnmarshalBinary decodes a header from b, the data of record 0 of a
+// MOBI book.
+func (h *Header) UnmarshalBinary(b []byte) error {
+	var pd palmdoc.Header
+	if err := pd.UnmarshalBinary(b); err != nil {
+		return err
+	}
+	if len(b) < offHeaderLength+4 || string(b[offIdentifier:offIdentifier+4]) != "MOBI" {
+		return fmt.Errorf("mobi: no MOBI header")
+	}
+	hl := binary.BigEndian.Uint32(b[offHeaderLength:])
+	end := palmDocSize + int(hl)
+	if hl < 8 || end > len(b) {
+		return fmt.Errorf("mobi: MOBI header length %v doesn't fit in a %v byte record", hl, len(b))
+	}
+
+	*h = Header{PalmDoc: pd, raw: append([]byte(nil), b...), headerLength: hl}
+	u32 := func(o int) uint32 {
+		if o+4 > end {
+			return 0
+		}
+		return binary.BigEndian.Uint32(b[o:])
+	}
+	u16 := func(o int) uint16 {
+		if o+2 > end {
+			return 0
+		}
+		return binary.BigEndian.Uint16(b[o:])
+	}
+	h.Type = u32(offType)
+	h.Encoding = u32(offEncoding)
+	h.UniqueID = u32(offUniqueID)
+	h.Version = u32(offVersion)
+	h.FirstNonBookRecord = u32(offFirstNonBook)
+	h.Locale = u32(offLocale)
+	h.InputLanguage = u32(offInputLanguage)
+	h.OutputLanguage = u32(offOutputLanguage)
+	h.MinVersion = u32(offMinVersion)
+	h.FirstImageRecord = u32(offFirstImage)
+	h.Flags = u32(offFlags)
+	h.FirstContentRecord = u16(offFirstContent)
+	h.LastContentRecord = u16(offLastContent)
+	if h.Version >= extraDataVersion {
+		h.ExtraDataFlags = u16(offExtraDataFlags)
+	}
+
+	h.exthEnd = end
+	if h.Flags&FlagEXTH != 0 {
+		if err := h.unmarshalEXTH(b, end); err != nil {
+			return err
+		}
+	}
+
+	no, nl := int(u32(offFullNameOffset)), int(u32(offFullNameLength))
+	if no+nl > len(b) || no+nl < no {
+		return fmt.Errorf("mobi: full name at %v, length %v, is past the end of the %v byte record", no, nl, len(b))
+	}
+	h.nameOffset, h.nameLength = no, nl
+	h.FullName = decodeText(h.Encoding, b[no:no+nl])
+	return nil
+}
+
+// MarshalBinary encodes the header as the data for record 0.
+func (h *Header) MarshalBinary() ([]byte, error) {
+	hl := h.headerLength
+	if hl == 0 {
+		hl = defaultHeaderLength
+	}
+	end := palmDocSize + int(hl)
+	b := make([]byte, end)
+	if h.raw != nil {
+		copy(b, h.raw)
+	} else {
+		// Index record numbers that aren't set are all 1s.
+		for o := 40; o < offFirstNonBook; o++ {
+			b[o] = 0xff
+		}
+	}
+
+	pd, err := h.PalmDoc.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	copy(b, pd)
+	copy(b[offIdentifier:], "MOBI")
+	binary.BigEndian.PutUint32(b[offHeaderLength:], hl)
+	put32 := func(o int, v uint32) {
+		if o+4 <= end {
+			binary.BigEndian.PutUint32(b[o:], v)
+		}
+	}
+	put16 := func(o int, v uint16) {
+		if o+2 <= end {
+			binary.BigEndian.PutUint16(b[o:], v)
+		}
+	}
+	put32(offType, h.Type)
+	put32(offEncoding, h.Encoding)
+	put32(offUniqueID, h.UniqueID)
+	put32(offVersion, h.Version)
+	put32(offFirstNonBook, h.FirstNonBookRecord)
+	put32(offLocale, h.Locale)
+	put32(offInputLanguage, h.InputLanguage)
+	put32(offOutputLanguage, h.OutputLanguage)
+	put32(offMinVersion, h.MinVersion)
+	put32(offFirstImage, h.FirstImageRecord)
+	flags := h.Flags &^ FlagEXTH
+	if len(h.EXTH) > 0 {
+		flags |= FlagEXTH
+	}
+	put32(offFlags, flags)
+	put16(offFirstContent, h.FirstContentRecord)
+	put16(offLastContent, h.LastContentRecord)
+	if h.Version >= extraDataVersion {
+		put16(offExtraDataFlags, h.ExtraDataFlags)
+	}
+
+	if len(h.EXTH) > 0 {
+		b = h.appendEXTH(b)
+	}
+
+	name, err := encodeText(h.Encoding, h.FullName)
+	if err != nil {
+		return nil, err
+	}
+	// Keep whatever was between the EXTH block and the full name, and
+	// whatever came after the full name, which is usually padding
+	// left for the name to grow into. If the name wasn't after the
+	// EXTH block, keep everything after the block instead.
+	var tail []byte
+	if h.raw != nil {
+		if h.nameOffset >= h.exthEnd {
+			b = append(b, h.raw[h.exthEnd:h.nameOffset]...)
+			tail = h.raw[h.nameOffset+h.nameLength:]
+		} else {
+			tail = h.raw[h.exthEnd:]
+		}
+	}
+	put32(offFullNameOffset, uint32(len(b)))
+	put32(offFullNameLength, uint32(len(name)))
+	b = append(b, name...)
+	if len(tail) < 2 {
+		// The name needs a couple of NULs after it, and the record
+		// is padded to a multiple of 4 bytes.
+		tail = make([]byte, 2+(4-(len(b)+2)%4)%4)
+	}
+	return append(b, tail...), nil
+}
@@ -0,0 +1,245 @@
+package mobi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/writingtoole/pdb"
+)
+
+// The sample file is a copy of Alice in Wonderland from Project
+// Gutenberg.
+const sampleFile = "../testdata/pg11-images.mobi"
+
+func readSample(t *testing.T) *pdb.Pdb {
+	p, err := pdb.Read(sampleFile)
+	if err != nil {
+		t.Fatalf("Unable to open %q: %v", sampleFile, err)
+	}
+	return p
+}
+
+func TestReadHeader(t *testing.T) {
+	p := readSample(t)
+	h, err := ReadHeader(p)
+	if err != nil {
+		t.Fatalf("ReadHeader error: %v", err)
+	}
+
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"Compression", h.PalmDoc.Compression, uint16(2)},
+		{"TextLength", h.PalmDoc.TextLength, uint32(0x2f47a)},
+		{"RecordCount", h.PalmDoc.RecordCount, uint16(48)},
+		{"RecordSize", h.PalmDoc.RecordSize, uint16(4096)},
+		{"Encryption", h.Encryption(), uint16(0)},
+		{"Type", h.Type, uint32(TypeBook)},
+		{"Encoding", h.Encoding, uint32(UTF8)},
+		{"UniqueID", h.UniqueID, uint32(0xf59752ec)},
+		{"Version", h.Version, uint32(6)},
+		{"FirstNonBookRecord", h.FirstNonBookRecord, uint32(50)},
+		{"FullName", h.FullName, "Alice's Adventures in Wonderland"},
+		{"Locale", h.Locale, uint32(9)},
+		{"MinVersion", h.MinVersion, uint32(6)},
+		{"FirstImageRecord", h.FirstImageRecord, uint32(53)},
+		{"Flags", h.Flags, uint32(0x850)},
+		{"FirstContentRecord", h.FirstContentRecord, uint16(1)},
+		{"LastContentRecord", h.LastContentRecord, uint16(0x35)},
+		{"ExtraDataFlags", h.ExtraDataFlags, uint16(3)},
+		{"EXTH records", len(h.EXTH), 19},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%v: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	if a, ok := h.EXTHString(EXTHAuthor); !ok || a != "Lewis Carroll" {
+		t.Errorf("author got %q/%v, want \"Lewis Carroll\"", a, ok)
+	}
+	if d, _ := h.EXTHString(EXTHPublishingDate); d != "2008-06-27" {
+		t.Errorf("publishing date got %q, want \"2008-06-27\"", d)
+	}
+	if s, ok := h.EXTHUint32(EXTHStartReading); !ok || s != 0xbce {
+		t.Errorf("start reading got %v/%v, want %v", s, ok, 0xbce)
+	}
+	if _, ok := h.EXTHUint32(EXTHCoverOffset); ok {
+		t.Errorf("got a cover offset, want none")
+	}
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	p := readSample(t)
+	raw := p.Records[0].Data
+	h, err := ReadHeader(p)
+	if err != nil {
+		t.Fatalf("ReadHeader error: %v", err)
+	}
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	if !bytes.Equal(b, raw) {
+		t.Errorf("unchanged header doesn't match the original")
+	}
+}
+
+// TestHeaderNoName checks a header whose full name offset and length
+// are both 0 keeps its size and everything after the EXTH block.
+func TestHeaderNoName(t *testing.T) {
+	p := readSample(t)
+	raw := append([]byte(nil), p.Records[0].Data...)
+	for o := offFullNameOffset; o < offFullNameLength+4; o++ {
+		raw[o] = 0
+	}
+	var h Header
+	if err := h.UnmarshalBinary(raw); err != nil {
+		t.Fatalf("UnmarshalBinary error: %v", err)
+	}
+	if h.FullName != "" {
+		t.Errorf("got name %q, want none", h.FullName)
+	}
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	if len(b) != len(raw) {
+		t.Fatalf("got %v bytes, want %v", len(b), len(raw))
+	}
+	// Only the name offset, which now points past the EXTH block,
+	// changes.
+	if !bytes.Equal(b[:offFullNameOffset], raw[:offFullNameOffset]) || !bytes.Equal(b[offFullNameOffset+4:], raw[offFullNameOffset+4:]) {
+		t.Errorf("header changed outside the name offset")
+	}
+	var got Header
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary of marshaled header error: %v", err)
+	}
+	if got.FullName != "" || len(got.EXTH) != len(h.EXTH) {
+		t.Errorf("got name %q and %v EXTH records, want no name and %v", got.FullName, len(got.EXTH), len(h.EXTH))
+	}
+}
+
+func TestHeaderEdit(t *testing.T) {
+	p := readSample(t)
+	h, err := ReadHeader(p)
+	if err != nil {
+		t.Fatalf("ReadHeader error: %v", err)
+	}
+	h.FullName = "Alice in Wonderland, the much longer edition"
+	h.Locale = 0x809
+	if err := h.SetEXTHString(EXTHAuthor, "Charles Dodgson"); err != nil {
+		t.Fatalf("SetEXTHString error: %v", err)
+	}
+	h.SetEXTHString(EXTHISBN, "9780000000000")
+	h.SetEXTHUint32(EXTHCoverOffset, 3)
+	h.DeleteEXTH(EXTHRights)
+	if err := WriteHeader(p, h); err != nil {
+		t.Fatalf("WriteHeader error: %v", err)
+	}
+
+	got, err := ReadHeader(p)
+	if err != nil {
+		t.Fatalf("ReadHeader of edited header error: %v", err)
+	}
+	if got.FullName != h.FullName || got.Locale != 0x809 {
+		t.Errorf("got name %q, locale %x", got.FullName, got.Locale)
+	}
+	if a := got.EXTHStrings(EXTHAuthor); len(a) != 1 || a[0] != "Charles Dodgson" {
+		t.Errorf("got authors %q, want [Charles Dodgson]", a)
+	}
+	// The author is replaced where it was.
+	if got.EXTH[2].Type != EXTHAuthor {
+		t.Errorf("EXTH record 2 is type %v, want the author", got.EXTH[2].Type)
+	}
+	if i, _ := got.EXTHString(EXTHISBN); i != "9780000000000" {
+		t.Errorf("got ISBN %q", i)
+	}
+	if c, _ := got.EXTHUint32(EXTHCoverOffset); c != 3 {
+		t.Errorf("got cover offset %v, want 3", c)
+	}
+	if _, ok := got.EXTHString(EXTHRights); ok {
+		t.Errorf("rights are still there")
+	}
+	// Two records were added and one removed.
+	if len(got.EXTH) != 20 {
+		t.Errorf("got %v EXTH records, want 20", len(got.EXTH))
+	}
+	// Nothing past the header moved.
+	if got.UniqueID != h.UniqueID || got.ExtraDataFlags != h.ExtraDataFlags {
+		t.Errorf("header fields changed")
+	}
+
+	// Dropping all the metadata clears the EXTH flag.
+	got.EXTH = nil
+	b, _ := got.MarshalBinary()
+	var h2 Header
+	if err := h2.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary error: %v", err)
+	}
+	if h2.Flags&FlagEXTH != 0 || len(h2.EXTH) != 0 || h2.FullName != h.FullName {
+		t.Errorf("got flags %x, %v EXTH records, name %q", h2.Flags, len(h2.EXTH), h2.FullName)
+	}
+}
+
+func TestNewHeader(t *testing.T) {
+	h := &Header{Type: TypeBook, Encoding: CP1252, Version: 6, FullName: "Café crème"}
+	h.PalmDoc.Compression = 2
+	h.SetEXTHString(EXTHAuthor, "Zoë")
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	if len(b)%4 != 0 {
+		t.Errorf("header is %v bytes, not a multiple of 4", len(b))
+	}
+	var got Header
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary error: %v", err)
+	}
+	if got.FullName != "Café crème" || got.Encoding != CP1252 || got.PalmDoc.Compression != 2 {
+		t.Errorf("got %+v", got)
+	}
+	if a, _ := got.EXTHString(EXTHAuthor); a != "Zoë" {
+		t.Errorf("got author %q, want \"Zoë\"", a)
+	}
+	// CP1252 names are stored a byte per character.
+	if int(got.nameLength) != len("Cafe creme") {
+		t.Errorf("name is %v bytes, want %v", got.nameLength, len("Cafe creme"))
+	}
+
+	h.FullName = "日本"
+	if _, err := h.MarshalBinary(); err == nil {
+		t.Errorf("MarshalBinary of a name CP1252 can't hold got no error, want one")
+	}
+}
+
+func TestHeaderErrors(t *testing.T) {
+	p := readSample(t)
+	good := p.Records[0].Data
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Too short", good[:10]},
+		{"No MOBI header", corrupt(func(b []byte) []byte { b[16] = 'X'; return b })},
+		{"Header too long", corrupt(func(b []byte) []byte { b[20] = 0xff; return b })},
+		{"No EXTH block", corrupt(func(b []byte) []byte { b[280] = 'X'; return b })},
+		{"Bad EXTH record length", corrupt(func(b []byte) []byte { b[296] = 0xff; return b })},
+		{"Full name past end", corrupt(func(b []byte) []byte { b[88] = 0xff; return b })},
+	}
+	for _, test := range tests {
+		var h Header
+		if err := h.UnmarshalBinary(test.data); err == nil {
+			t.Errorf("%v: got no error, want one", test.name)
+		}
+	}
+	if _, err := ReadHeader(&pdb.Pdb{}); err == nil {
+		t.Errorf("ReadHeader with no records got no error, want one")
+	}
+}
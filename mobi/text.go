@@ -0,0 +1,105 @@
+package mobi
+
+import (
+	"fmt"
+
+	"github.com/writingtoole/pdb"
+	"github.com/writingtoole/pdb/palmdoc"
+)
+
+// HTML returns the text of the MOBI book p, which is HTML, converted
+// to UTF-8. The trailing entries the header's extra data flags say are
+// at the end of each text record are stripped before the record is
+// decompressed. Encrypted books and books using HUFF/CDIC compression
+// aren't supported.
+func HTML(p *pdb.Pdb) ([]byte, error) {
+	h, err := ReadHeader(p)
+	if err != nil {
+		return nil, err
+	}
+	if e := h.Encryption(); e != 0 {
+		return nil, fmt.Errorf("mobi: book is encrypted (type %v)", e)
+	}
+	n := int(h.PalmDoc.RecordCount)
+	if n+1 > len(p.Records) {
+		return nil, fmt.Errorf("mobi: header says there are %v text records, but there are only %v records after it", n, len(p.Records)-1)
+	}
+
+	// The text length comes from the file, so don't trust it any
+	// further than the records can hold.
+	size := n * palmdoc.RecordSize
+	if int64(h.PalmDoc.TextLength) < int64(size) {
+		size = int(h.PalmDoc.TextLength)
+	}
+	text := make([]byte, 0, size)
+	for i := 1; i <= n; i++ {
+		data, err := TrimTrailingEntries(p.Records[i].Data, h.ExtraDataFlags)
+		if err != nil {
+			return nil, fmt.Errorf("mobi: record %v: %w", i, err)
+		}
+		text, err = palmdoc.AppendRecordText(text, h.PalmDoc.Compression, data)
+		if err != nil {
+			return nil, fmt.Errorf("mobi: record %v: %w", i, err)
+		}
+	}
+	// Some writers pad the last record.
+	if len(text) > int(h.PalmDoc.TextLength) {
+		text = text[:h.PalmDoc.TextLength]
+	}
+	if h.Encoding == CP1252 {
+		text = decodeCP1252(text)
+	}
+	return text, nil
+}
+
+// TrimTrailingEntries returns data, the data of a MOBI text record,
+// without the trailing entries flags says are at the end of it. flags
+// is the header's ExtraDataFlags.
+//
+// Each bit of flags from bit 1 up says there's an entry, with the
+// lowest bit's entry last in the record. Those entries end with their
+// size, written backwards 7 bits at a time, which counts the size
+// bytes too. Bit 0 says there's a multibyte character entry before
+// them, whose last byte holds in its low two bits how many bytes come
+// before it.
+func TrimTrailingEntries(data []byte, flags uint16) ([]byte, error) {
+	for f := flags >> 1; f != 0; f >>= 1 {
+		if f&1 == 0 {
+			continue
+		}
+		size, err := trailingEntrySize(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[:len(data)-size]
+	}
+	if flags&1 != 0 {
+		if len(data) == 0 {
+			return nil, fmt.Errorf("mobi: no room for multibyte trailing entry")
+		}
+		size := int(data[len(data)-1]&3) + 1
+		if size > len(data) {
+			return nil, fmt.Errorf("mobi: multibyte trailing entry of %v bytes is bigger than the %v bytes left", size, len(data))
+		}
+		data = data[:len(data)-size]
+	}
+	return data, nil
+}
+
+// trailingEntrySize reads the size of the trailing entry at the end of
+// data. The size is up to 4 bytes of 7 bits each, most significant
+// first, with the high bit set on the first byte. It's read backwards
+// from the last byte of data.
+func trailingEntrySize(data []byte) (int, error) {
+	size := 0
+	for o := len(data) - 1; o >= 0 && o >= len(data)-4; o-- {
+		size |= int(data[o]&0x7f) << (7 * uint(len(data)-1-o))
+		if data[o]&0x80 != 0 {
+			break
+		}
+	}
+	if size == 0 || size > len(data) {
+		return 0, fmt.Errorf("mobi: trailing entry of %v bytes doesn't fit in the %v bytes left", size, len(data))
+	}
+	return size, nil
+}
@@ -0,0 +1,222 @@
+package mobi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/writingtoole/pdb"
+)
+
+func TestHTML(t *testing.T) {
+	p := readSample(t)
+	h, err := ReadHeader(p)
+	if err != nil {
+		t.Fatalf("ReadHeader error: %v", err)
+	}
+	got, err := HTML(p)
+	if err != nil {
+		t.Fatalf("HTML error: %v", err)
+	}
+	if len(got) != int(h.PalmDoc.TextLength) {
+		t.Errorf("got %v bytes, want %v", len(got), h.PalmDoc.TextLength)
+	}
+	// Trailing entries left in would show up as junk in the middle of
+	// the text.
+	if !utf8.Valid(got) {
+		t.Errorf("text isn't valid UTF-8")
+	}
+	if !bytes.HasPrefix(got, []byte("<html")) {
+		t.Errorf("text starts %q, want <html", got[:20])
+	}
+	if !bytes.HasSuffix(bytes.TrimSpace(got), []byte("</html>")) {
+		t.Errorf("text doesn't end with </html>")
+	}
+	if !bytes.Contains(got, []byte("Down the Rabbit-Hole")) {
+		t.Errorf("text doesn't contain the first chapter title")
+	}
+}
+
+// TestHTMLTextLength checks a bogus text length in the header isn't
+// trusted.
+func TestHTMLTextLength(t *testing.T) {
+	p := readSample(t)
+	h, err := ReadHeader(p)
+	if err != nil {
+		t.Fatalf("ReadHeader error: %v", err)
+	}
+	want := h.PalmDoc.TextLength
+	h.PalmDoc.TextLength = 0xfffffff0
+	if err := WriteHeader(p, h); err != nil {
+		t.Fatalf("WriteHeader error: %v", err)
+	}
+	got, err := HTML(p)
+	if err != nil {
+		t.Fatalf("HTML error: %v", err)
+	}
+	if len(got) != int(want) {
+		t.Errorf("got %v bytes, want %v", len(got), want)
+	}
+	if max := int(h.PalmDoc.RecordCount) * 4096; cap(got) > max {
+		t.Errorf("got a %v byte buffer, want no more than %v", cap(got), max)
+	}
+}
+
+func TestTrimTrailingEntries(t *testing.T) {
+	text := []byte("hello")
+	// A multibyte entry holding one byte, then entries for bits 1 and
+	// 2, the bit 1 entry last.
+	multibyte := []byte{0xaa, 0x01}
+	bit2 := []byte{0x7f, 0x82}
+	bit1 := []byte{0x10, 0x20, 0x83}
+	all := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{text}, parts...), nil)
+	}
+	// A size over 127, taking two bytes.
+	long := append(bytes.Repeat([]byte{'x'}, 200-2), 0x81, 0x48)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		flags uint16
+	}{
+		{"None", all(), 0},
+		{"Multibyte", all(multibyte), 1},
+		{"Bit 1", all(bit1), 2},
+		{"Multibyte and bit 1", all(multibyte, bit1), 3},
+		{"Bits 1 and 2", all(bit2, bit1), 6},
+		{"Everything", all(multibyte, bit2, bit1), 7},
+		{"Long entry", all(long), 2},
+	}
+	for _, test := range tests {
+		got, err := TrimTrailingEntries(test.data, test.flags)
+		if err != nil {
+			t.Errorf("%v: error %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(got, text) {
+			t.Errorf("%v: got %q, want %q", test.name, got, text)
+		}
+	}
+
+	bad := []struct {
+		name  string
+		data  []byte
+		flags uint16
+	}{
+		{"Empty", nil, 2},
+		{"Entry too big", []byte{'a', 0x85}, 2},
+		{"Zero size", []byte{'a', 0x80}, 2},
+		{"Multibyte too big", []byte{0x03}, 1},
+	}
+	for _, test := range bad {
+		if _, err := TrimTrailingEntries(test.data, test.flags); err == nil {
+			t.Errorf("%v: got no error, want one", test.name)
+		}
+	}
+}
+
+// newBook returns a MOBI book holding the given text records, stored
+// uncompressed in CP1252.
+func newBook(t *testing.T, flags uint16, records ...[]byte) *pdb.Pdb {
+	h := &Header{Type: TypeBook, Encoding: CP1252, Version: 6, FullName: "Test", ExtraDataFlags: flags}
+	h.PalmDoc.Compression = 1
+	h.PalmDoc.RecordCount = uint16(len(records))
+	h.PalmDoc.RecordSize = 4096
+	for _, r := range records {
+		h.PalmDoc.TextLength += uint32(len(r))
+	}
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	p := &pdb.Pdb{}
+	if _, err := p.AppendRecord(b); err != nil {
+		t.Fatalf("AppendRecord error: %v", err)
+	}
+	for _, r := range records {
+		if _, err := p.AppendRecord(r); err != nil {
+			t.Fatalf("AppendRecord error: %v", err)
+		}
+	}
+	return p
+}
+
+func TestHTMLCP1252(t *testing.T) {
+	p := newBook(t, 2, []byte("<p>\x93Caf\xe9\x94\x81"), []byte("</p>\x81"))
+	h, _ := ReadHeader(p)
+	tests := []struct {
+		// The text length counts the CP1252 text, not the trailing
+		// entries.
+		length uint32
+		want   string
+	}{
+		{13, "<p>“Café”</p>"},
+		// The text is cut short before it's converted to UTF-8.
+		{9, "<p>“Café”"},
+	}
+	for _, test := range tests {
+		h.PalmDoc.TextLength = test.length
+		WriteHeader(p, h)
+		got, err := HTML(p)
+		if err != nil {
+			t.Fatalf("HTML error: %v", err)
+		}
+		if string(got) != test.want {
+			t.Errorf("text length %v: got %q, want %q", test.length, got, test.want)
+		}
+	}
+}
+
+// TestHTMLOldVersion checks the extra data flags are ignored in books
+// from before MOBI version 5, which didn't have them.
+func TestHTMLOldVersion(t *testing.T) {
+	p := newBook(t, 3, []byte("<p>old\x81"))
+	h, _ := ReadHeader(p)
+	h.Version = 4
+	WriteHeader(p, h)
+	if binary.BigEndian.Uint16(p.Records[0].Data[offExtraDataFlags:]) != 3 {
+		t.Fatalf("flags aren't left in the header")
+	}
+
+	h, _ = ReadHeader(p)
+	if h.ExtraDataFlags != 0 {
+		t.Errorf("got extra data flags %v, want 0", h.ExtraDataFlags)
+	}
+	got, err := HTML(p)
+	if err != nil {
+		t.Fatalf("HTML error: %v", err)
+	}
+	if want := "<p>old\u0081"; string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHTMLErrors(t *testing.T) {
+	p := newBook(t, 0, []byte("text"))
+	p.Records = p.Records[:1]
+	if _, err := HTML(p); err == nil {
+		t.Errorf("HTML with missing text records got no error, want one")
+	}
+
+	p = newBook(t, 2, []byte{0x85})
+	if _, err := HTML(p); err == nil {
+		t.Errorf("HTML with a bad trailing entry got no error, want one")
+	}
+
+	p = newBook(t, 0, []byte("text"))
+	h, _ := ReadHeader(p)
+	h.PalmDoc.CurrentPosition = 2 << 16
+	WriteHeader(p, h)
+	if _, err := HTML(p); err == nil {
+		t.Errorf("HTML of an encrypted book got no error, want one")
+	}
+
+	h.PalmDoc.CurrentPosition = 0
+	h.PalmDoc.Compression = 17480
+	WriteHeader(p, h)
+	if _, err := HTML(p); err == nil {
+		t.Errorf("HTML of a HUFF/CDIC book got no error, want one")
+	}
+}
@@ -0,0 +1,135 @@
+package palmdoc
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/writingtoole/pdb"
+	"github.com/writingtoole/pdb/lz77"
+)
+
+// Builder builds a PalmDoc book from text written to it a piece at a
+// time. Each record is compressed as soon as it fills up.
+type Builder struct {
+	name        string
+	compression uint16
+	level       int
+	// Text that doesn't fill a record yet.
+	buf []byte
+	// Finished text records.
+	records   [][]byte
+	length    int64
+	bookmarks []Bookmark
+}
+
+// NewBuilder returns a Builder for a book called name whose text
+// records use the given compression, either NoCompression or
+// PalmDocCompression.
+func NewBuilder(name string, compression uint16) (*Builder, error) {
+	if compression != NoCompression && compression != PalmDocCompression {
+		return nil, fmt.Errorf("palmdoc: can't write compression type %v", compression)
+	}
+	return &Builder{
+		name:        name,
+		compression: compression,
+		level:       lz77.BestSpeed,
+		buf:         make([]byte, 0, RecordSize),
+	}, nil
+}
+
+// SetLevel sets the lz77 compression level, one of the levels
+// lz77.CompressLevel takes, for records written from now on. The
+// default is lz77.BestSpeed.
+func (b *Builder) SetLevel(level int) error {
+	if level < lz77.BestSpeed || level > lz77.BestCompression {
+		return fmt.Errorf("palmdoc: invalid compression level %v", level)
+	}
+	b.level = level
+	return nil
+}
+
+// Write adds p to the end of the text.
+func (b *Builder) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(b.buf[len(b.buf):RecordSize], p)
+		b.buf = b.buf[:len(b.buf)+n]
+		p = p[n:]
+		written += n
+		b.length += int64(n)
+		if len(b.buf) == RecordSize {
+			b.records = append(b.records, b.record())
+			b.buf = b.buf[:0]
+		}
+	}
+	return written, nil
+}
+
+// WriteString adds s to the end of the text.
+func (b *Builder) WriteString(s string) (int, error) {
+	return b.Write([]byte(s))
+}
+
+// record returns the buffered text as it's stored in a record.
+func (b *Builder) record() []byte {
+	if b.compression == NoCompression {
+		return append([]byte(nil), b.buf...)
+	}
+	d, _ := lz77.CompressLevel(b.buf, b.level)
+	return d
+}
+
+// AddBookmark adds a bookmark called name at the current end of the
+// text. Names can be up to 15 bytes long.
+func (b *Builder) AddBookmark(name string) error {
+	if len(name) >= bookmarkNameSize {
+		return fmt.Errorf("palmdoc: bookmark name %q is longer than %v bytes", name, bookmarkNameSize-1)
+	}
+	if b.length > 0xffffffff {
+		return fmt.Errorf("palmdoc: bookmark position %v is past 4GB", b.length)
+	}
+	b.bookmarks = append(b.bookmarks, Bookmark{Name: name, Position: uint32(b.length)})
+	return nil
+}
+
+// Pdb returns the book built so far as a PalmDoc database. More text
+// can still be written to the Builder afterwards.
+func (b *Builder) Pdb() (*pdb.Pdb, error) {
+	records := b.records
+	if len(b.buf) > 0 {
+		records = append(records[:len(records):len(records)], b.record())
+	}
+	if len(records) > 0xffff {
+		return nil, fmt.Errorf("palmdoc: %v text records, more than the header can hold", len(records))
+	}
+	if b.length > 0xffffffff {
+		return nil, fmt.Errorf("palmdoc: text is %v bytes, more than the header can hold", b.length)
+	}
+
+	h := Header{
+		Compression: b.compression,
+		TextLength:  uint32(b.length),
+		RecordCount: uint16(len(records)),
+		RecordSize:  RecordSize,
+	}
+	hb, _ := h.MarshalBinary()
+
+	p := &pdb.Pdb{Name: b.name, Filetype: Filetype, Creator: Creator}
+	if _, err := p.AppendRecord(hb); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if _, err := p.AppendRecord(r); err != nil {
+			return nil, err
+		}
+	}
+	for _, bm := range b.bookmarks {
+		d := make([]byte, bookmarkSize)
+		copy(d, bm.Name)
+		binary.BigEndian.PutUint32(d[bookmarkNameSize:], bm.Position)
+		if _, err := p.AppendRecord(d); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
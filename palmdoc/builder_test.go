@@ -0,0 +1,108 @@
+package palmdoc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/writingtoole/pdb"
+	"github.com/writingtoole/pdb/lz77"
+)
+
+func TestBuilder(t *testing.T) {
+	text, err := ioutil.ReadFile("../lz77/testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+
+	for _, compression := range []uint16{NoCompression, PalmDocCompression} {
+		b, err := NewBuilder("ioutil", compression)
+		if err != nil {
+			t.Fatalf("NewBuilder error: %v", err)
+		}
+		if err := b.SetLevel(lz77.BestCompression); err != nil {
+			t.Fatalf("SetLevel error: %v", err)
+		}
+		b.Write(text[:5000])
+		if err := b.AddBookmark("Middle"); err != nil {
+			t.Fatalf("AddBookmark error: %v", err)
+		}
+		b.Write(text[5000:])
+		b.AddBookmark("End")
+
+		p, err := b.Pdb()
+		if err != nil {
+			t.Fatalf("Pdb error: %v", err)
+		}
+		if p.Filetype != "TEXt" || p.Creator != "REAd" {
+			t.Errorf("got type/creator %v/%v, want TEXt/REAd", p.Filetype, p.Creator)
+		}
+
+		// Write it out and read it back in.
+		var buf bytes.Buffer
+		if err := p.WriteFH(&buf); err != nil {
+			t.Fatalf("WriteFH error: %v", err)
+		}
+		p, err = pdb.ReadFH(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("ReadFH error: %v", err)
+		}
+		book, err := Read(p)
+		if err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+
+		wantRecords := (len(text) + RecordSize - 1) / RecordSize
+		want := Header{Compression: compression, TextLength: uint32(len(text)), RecordCount: uint16(wantRecords), RecordSize: RecordSize}
+		if book.Header != want {
+			t.Errorf("got header %+v, want %+v", book.Header, want)
+		}
+		if !bytes.Equal(book.Text, text) {
+			t.Errorf("compression %v: text doesn't match", compression)
+		}
+		wantMarks := []Bookmark{{"Middle", 5000}, {"End", uint32(len(text))}}
+		if len(book.Bookmarks) != 2 || book.Bookmarks[0] != wantMarks[0] || book.Bookmarks[1] != wantMarks[1] {
+			t.Errorf("got bookmarks %v, want %v", book.Bookmarks, wantMarks)
+		}
+		if compression == PalmDocCompression && len(p.Records[1].Data) >= RecordSize {
+			t.Errorf("first record is %v bytes; it isn't compressed", len(p.Records[1].Data))
+		}
+	}
+}
+
+func TestBuilderKeepsGoing(t *testing.T) {
+	b, _ := NewBuilder("more", PalmDocCompression)
+	b.WriteString("Some text. ")
+	p, err := b.Pdb()
+	if err != nil {
+		t.Fatalf("Pdb error: %v", err)
+	}
+	b.WriteString(strings.Repeat("More text. ", 1000))
+	p2, err := b.Pdb()
+	if err != nil {
+		t.Fatalf("Pdb error: %v", err)
+	}
+
+	book, _ := Read(p)
+	if string(book.Text) != "Some text. " {
+		t.Errorf("first book got %q", book.Text)
+	}
+	book, _ = Read(p2)
+	if want := "Some text. " + strings.Repeat("More text. ", 1000); string(book.Text) != want {
+		t.Errorf("second book text doesn't match")
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	if _, err := NewBuilder("huff", HuffCdicCompression); err == nil {
+		t.Errorf("NewBuilder with HUFF/CDIC got no error, want one")
+	}
+	b, _ := NewBuilder("errors", PalmDocCompression)
+	if err := b.SetLevel(0); err == nil {
+		t.Errorf("SetLevel(0) got no error, want one")
+	}
+	if err := b.AddBookmark("A name that's far too long"); err == nil {
+		t.Errorf("AddBookmark with a long name got no error, want one")
+	}
+}
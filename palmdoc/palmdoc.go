@@ -0,0 +1,166 @@
+// Package palmdoc reads and writes PalmDoc books, the plain text
+// ebooks that PalmOS readers use (type TEXt, creator REAd).
+//
+// A PalmDoc book is a PDB whose record 0 is a 16 byte header,
+// followed by the text split into records of up to 4096 bytes, each
+// usually compressed with lz77. Any bookmarks come after the text, one
+// per record.
+package palmdoc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/writingtoole/pdb"
+	"github.com/writingtoole/pdb/lz77"
+)
+
+// Database type and creator of PalmDoc books.
+const (
+	Filetype = "TEXt"
+	Creator  = "REAd"
+)
+
+// Compression types.
+const (
+	// The text is stored as is.
+	NoCompression = 1
+	// The text is compressed with lz77.
+	PalmDocCompression = 2
+	// The text is compressed with the HUFF/CDIC scheme some MOBI
+	// books use, which this package doesn't support.
+	HuffCdicCompression = 17480
+)
+
+// RecordSize is the most text a record holds.
+const RecordSize = 4096
+
+// Sizes of the header and bookmark records.
+const (
+	headerSize       = 16
+	bookmarkNameSize = 16
+	bookmarkSize     = bookmarkNameSize + 4
+)
+
+// Header is the PalmDoc header at the start of record 0. MOBI books
+// start their record 0 with the same header.
+type Header struct {
+	// How the text records are compressed.
+	Compression uint16
+	// Unused in PalmDoc books.
+	Reserved uint16
+	// Length of the uncompressed text.
+	TextLength uint32
+	// Number of text records.
+	RecordCount uint16
+	// Most uncompressed text a record holds, always 4096.
+	RecordSize uint16
+	// Where in the text the reader was last. MOBI books use this for
+	// the encryption type and an unknown field.
+	CurrentPosition uint32
+}
+
+// UnmarshalBinary decodes a header from the start of b, which is
+// usually the data of record 0. Anything after the header is ignored.
+func (h *Header) UnmarshalBinary(b []byte) error {
+	if len(b) < headerSize {
+		return fmt.Errorf("palmdoc: header needs %v bytes, only got %v", headerSize, len(b))
+	}
+	h.Compression = binary.BigEndian.Uint16(b[0:])
+	h.Reserved = binary.BigEndian.Uint16(b[2:])
+	h.TextLength = binary.BigEndian.Uint32(b[4:])
+	h.RecordCount = binary.BigEndian.Uint16(b[8:])
+	h.RecordSize = binary.BigEndian.Uint16(b[10:])
+	h.CurrentPosition = binary.BigEndian.Uint32(b[12:])
+	return nil
+}
+
+// MarshalBinary encodes the header.
+func (h *Header) MarshalBinary() ([]byte, error) {
+	b := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(b[0:], h.Compression)
+	binary.BigEndian.PutUint16(b[2:], h.Reserved)
+	binary.BigEndian.PutUint32(b[4:], h.TextLength)
+	binary.BigEndian.PutUint16(b[8:], h.RecordCount)
+	binary.BigEndian.PutUint16(b[10:], h.RecordSize)
+	binary.BigEndian.PutUint32(b[12:], h.CurrentPosition)
+	return b, nil
+}
+
+// Bookmark is a named position in the text.
+type Bookmark struct {
+	// Up to 15 bytes.
+	Name string
+	// Offset in the uncompressed text.
+	Position uint32
+}
+
+// Book is a PalmDoc book.
+type Book struct {
+	Header Header
+	// The uncompressed text.
+	Text      []byte
+	Bookmarks []Bookmark
+}
+
+// Read decodes the PalmDoc book held in p. It doesn't check the
+// database's type and creator, since other formats start the same
+// way, but it only handles uncompressed and lz77 compressed text.
+func Read(p *pdb.Pdb) (*Book, error) {
+	if len(p.Records) == 0 {
+		return nil, fmt.Errorf("palmdoc: no header record")
+	}
+	b := &Book{}
+	if err := b.Header.UnmarshalBinary(p.Records[0].Data); err != nil {
+		return nil, err
+	}
+	switch b.Header.Compression {
+	case NoCompression, PalmDocCompression:
+	default:
+		return nil, fmt.Errorf("palmdoc: unsupported compression type %v", b.Header.Compression)
+	}
+	n := int(b.Header.RecordCount)
+	if n+1 > len(p.Records) {
+		return nil, fmt.Errorf("palmdoc: header says there are %v text records, but there are only %v records after it", n, len(p.Records)-1)
+	}
+
+	b.Text = make([]byte, 0, n*RecordSize)
+	for i := 1; i <= n; i++ {
+		var err error
+		b.Text, err = AppendRecordText(b.Text, b.Header.Compression, p.Records[i].Data)
+		if err != nil {
+			return nil, fmt.Errorf("palmdoc: record %v: %w", i, err)
+		}
+	}
+
+	// Every record after the text is a bookmark. Anything that isn't
+	// the right size for one is skipped.
+	for _, r := range p.Records[n+1:] {
+		if len(r.Data) != bookmarkSize {
+			continue
+		}
+		name := string(r.Data[:bookmarkNameSize])
+		if e := strings.IndexByte(name, 0); e != -1 {
+			name = name[:e]
+		}
+		b.Bookmarks = append(b.Bookmarks, Bookmark{Name: name, Position: binary.BigEndian.Uint32(r.Data[bookmarkNameSize:])})
+	}
+
+	return b, nil
+}
+
+// AppendRecordText decodes the text in a record's data, stored with
+// the given compression type, and appends it to dst.
+func AppendRecordText(dst []byte, compression uint16, data []byte) ([]byte, error) {
+	switch compression {
+	case NoCompression:
+		return append(dst, data...), nil
+	case PalmDocCompression:
+		return lz77.AppendDecompress(dst, data)
+	case HuffCdicCompression:
+		return dst, fmt.Errorf("palmdoc: HUFF/CDIC compression isn't supported")
+	default:
+		return dst, fmt.Errorf("palmdoc: unknown compression type %v", compression)
+	}
+}
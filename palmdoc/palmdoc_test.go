@@ -0,0 +1,100 @@
+package palmdoc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/writingtoole/pdb"
+)
+
+func TestHeader(t *testing.T) {
+	raw := []byte{0, 2, 0, 0, 0, 1, 0, 0, 0, 16, 0x10, 0, 0, 0, 0x12, 0x34, 0xff}
+	want := Header{Compression: 2, TextLength: 65536, RecordCount: 16, RecordSize: 4096, CurrentPosition: 0x1234}
+	var h Header
+	if err := h.UnmarshalBinary(raw); err != nil {
+		t.Fatalf("UnmarshalBinary error: %v", err)
+	}
+	if h != want {
+		t.Errorf("got %+v, want %+v", h, want)
+	}
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	if !bytes.Equal(b, raw[:headerSize]) {
+		t.Errorf("MarshalBinary got %v, want %v", b, raw[:headerSize])
+	}
+	if err := h.UnmarshalBinary(raw[:headerSize-1]); err == nil {
+		t.Errorf("UnmarshalBinary of a short header got no error, want one")
+	}
+}
+
+// book returns a database holding a PalmDoc book with the text and
+// bookmark records passed in.
+func book(t *testing.T, compression uint16, textLen int, records ...[]byte) *pdb.Pdb {
+	h := Header{Compression: compression, TextLength: uint32(textLen), RecordCount: uint16(len(records)), RecordSize: RecordSize}
+	hb, _ := h.MarshalBinary()
+	p := &pdb.Pdb{Name: "test", Filetype: Filetype, Creator: Creator}
+	for _, r := range append([][]byte{hb}, records...) {
+		if _, err := p.AppendRecord(r); err != nil {
+			t.Fatalf("AppendRecord error: %v", err)
+		}
+	}
+	return p
+}
+
+func TestRead(t *testing.T) {
+	p := book(t, NoCompression, 11, []byte("hello "), []byte("world"))
+	mark := make([]byte, bookmarkSize)
+	copy(mark, "Chapter 1")
+	mark[bookmarkSize-1] = 6
+	p.AppendRecord(mark)
+	// Not a bookmark.
+	p.AppendRecord([]byte("junk"))
+
+	b, err := Read(p)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(b.Text) != "hello world" {
+		t.Errorf("got text %q, want %q", b.Text, "hello world")
+	}
+	if len(b.Bookmarks) != 1 || b.Bookmarks[0] != (Bookmark{"Chapter 1", 6}) {
+		t.Errorf("got bookmarks %v, want [{Chapter 1 6}]", b.Bookmarks)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *pdb.Pdb
+	}{
+		{"No records", &pdb.Pdb{}},
+		{"Short header", &pdb.Pdb{Records: []*pdb.Record{{Data: []byte{0, 2}}}}},
+		{"HUFF/CDIC", book(t, HuffCdicCompression, 0)},
+		{"Unknown compression", book(t, 3, 0)},
+		{"Missing text records", func() *pdb.Pdb {
+			p := book(t, PalmDocCompression, 3, []byte("abc"))
+			p.Records = p.Records[:1]
+			return p
+		}()},
+		{"Corrupt record", book(t, PalmDocCompression, 3, []byte{'a', 0x80})},
+	}
+
+	for _, test := range tests {
+		if _, err := Read(test.p); err == nil {
+			t.Errorf("%v: got no error, want one", test.name)
+		}
+	}
+}
+
+func TestAppendRecordText(t *testing.T) {
+	got, err := AppendRecordText([]byte("ab"), PalmDocCompression, []byte{'c', 'd', 'e', 0x80, 0x18})
+	if err != nil || string(got) != "abcdecde" {
+		t.Errorf("got %q/%v, want \"abcdecde\"", got, err)
+	}
+	if _, err := AppendRecordText(nil, HuffCdicCompression, []byte("x")); err == nil || !strings.Contains(err.Error(), "HUFF") {
+		t.Errorf("HUFF/CDIC got error %v, want one about HUFF/CDIC", err)
+	}
+}
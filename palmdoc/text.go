@@ -0,0 +1,69 @@
+package palmdoc
+
+import (
+	"fmt"
+
+	"github.com/writingtoole/pdb"
+	"github.com/writingtoole/pdb/lz77"
+)
+
+// Text gives random access to the lz77 compressed text records of a
+// Pdb, decompressing only the records that hold the text asked for.
+// It works like lz77.Text, except records are numbered the way they
+// are in the Pdb.
+type Text struct {
+	*lz77.Text
+	first int
+}
+
+// NewText returns a Text for the count records of p starting with
+// record first.
+func NewText(p *pdb.Pdb, first, count int) (*Text, error) {
+	if first < 0 || count < 0 || first+count > len(p.Records) {
+		return nil, fmt.Errorf("palmdoc: records %v-%v out of range; database has %v records", first, first+count-1, len(p.Records))
+	}
+	t, err := lz77.NewText(count, func(i int) ([]byte, error) {
+		return p.Records[first+i].Data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Text{Text: t, first: first}, nil
+}
+
+// OpenText returns a Text for the text records of the PalmDoc book p,
+// as given by its header. The text must be lz77 compressed.
+func OpenText(p *pdb.Pdb) (*Text, error) {
+	if len(p.Records) == 0 {
+		return nil, fmt.Errorf("palmdoc: no header record")
+	}
+	var h Header
+	if err := h.UnmarshalBinary(p.Records[0].Data); err != nil {
+		return nil, err
+	}
+	if h.Compression != PalmDocCompression {
+		return nil, fmt.Errorf("palmdoc: text with compression type %v isn't lz77 compressed", h.Compression)
+	}
+	return NewText(p, 1, int(h.RecordCount))
+}
+
+// Locate returns the index of the record in the Pdb that holds byte
+// off of the text, and where in that record's decompressed text the
+// byte is.
+func (t *Text) Locate(off int64) (int, int, error) {
+	i, local, err := t.Text.Locate(off)
+	if err != nil {
+		return 0, 0, err
+	}
+	return t.first + i, local, nil
+}
+
+// Record returns the decompressed text of record i of the Pdb, which
+// must be one of the Text's records. The returned slice may be shared
+// with other callers, so it must not be modified.
+func (t *Text) Record(i int) ([]byte, error) {
+	if i < t.first {
+		return nil, fmt.Errorf("palmdoc: record %v isn't part of the text", i)
+	}
+	return t.Text.Record(i - t.first)
+}
@@ -0,0 +1,76 @@
+package palmdoc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	text, err := ioutil.ReadFile("../lz77/testdata/ioutil.html")
+	if err != nil {
+		t.Fatalf("error reading test data: %v", err)
+	}
+	b, _ := NewBuilder("ioutil", PalmDocCompression)
+	b.Write(text)
+	b.AddBookmark("End")
+	p, err := b.Pdb()
+	if err != nil {
+		t.Fatalf("Pdb error: %v", err)
+	}
+
+	tx, err := OpenText(p)
+	if err != nil {
+		t.Fatalf("OpenText error: %v", err)
+	}
+	if tx.Size() != int64(len(text)) {
+		t.Errorf("Size() got %v, want %v", tx.Size(), len(text))
+	}
+	got, err := ioutil.ReadAll(io.NewSectionReader(tx, 0, tx.Size()))
+	if err != nil || !bytes.Equal(got, text) {
+		t.Errorf("text doesn't match (error %v)", err)
+	}
+
+	// Record 0 is the header, so the text starts in record 1.
+	for _, off := range []int64{0, RecordSize - 1, RecordSize, int64(len(text)) - 1} {
+		rec, local, err := tx.Locate(off)
+		if err != nil {
+			t.Errorf("Locate(%v) error: %v", off, err)
+			continue
+		}
+		if wantRec, wantLocal := int(off/RecordSize)+1, int(off%RecordSize); rec != wantRec || local != wantLocal {
+			t.Errorf("Locate(%v) got %v/%v, want %v/%v", off, rec, local, wantRec, wantLocal)
+		}
+		d, err := tx.Record(rec)
+		if err != nil {
+			t.Errorf("Record(%v) error: %v", rec, err)
+			continue
+		}
+		if d[local] != text[off] {
+			t.Errorf("Record(%v)[%v] got %q, want %q", rec, local, d[local], text[off])
+		}
+	}
+
+	last := int(tx.Size()-1)/RecordSize + 1
+	for _, i := range []int{0, last + 1} {
+		if _, err := tx.Record(i); err == nil {
+			t.Errorf("Record(%v) got no error, want one", i)
+		}
+	}
+}
+
+func TestTextErrors(t *testing.T) {
+	b, _ := NewBuilder("plain", NoCompression)
+	b.WriteString("plain text")
+	p, _ := b.Pdb()
+	if _, err := OpenText(p); err == nil {
+		t.Errorf("OpenText of uncompressed text got no error, want one")
+	}
+	if _, err := NewText(p, 1, len(p.Records)); err == nil {
+		t.Errorf("NewText past the last record got no error, want one")
+	}
+	if _, err := NewText(p, -1, 1); err == nil {
+		t.Errorf("NewText before the first record got no error, want one")
+	}
+}
@@ -11,11 +11,23 @@ import (
 	"time"
 )
 
-// Record holds the contents of a single record in the PDB file.
-type Record struct {
+// extent notes where a record or resource lives in the PDB file.
+type extent struct {
 	offset uint32
 	end    uint32
-	// Record attributes
+}
+
+// size returns the number of bytes the record or resource takes up
+// in the file it was read from.
+func (e *extent) size() int {
+	return int(e.end - e.offset + 1)
+}
+
+// Record holds the contents of a single record in the PDB file.
+type Record struct {
+	extent
+	// Record attributes and category. Attributes gives them as
+	// RecordAttributes.
 	Attribs int8
 	// Unique ID for the record. Often just the record number. Pay no
 	// attention to the top byte, this is a 24 bit integer.
@@ -24,11 +36,23 @@ type Record struct {
 	Data []byte
 }
 
+// Resource holds the contents of a single resource in a resource
+// database, such as a PalmOS application (.prc file).
+type Resource struct {
+	extent
+	// Four character code noting the resource type.
+	Type string
+	// Resource ID. The type and ID together identify the resource.
+	ID uint16
+	// Contents of the resource.
+	Data []byte
+}
+
 // Pdb represents the contents of a PDB file.
 type Pdb struct {
 	// Database name.
 	Name string
-	// Attribute bits
+	// Attribute bits. DBAttributes gives them as DBAttributes.
 	Attributes uint16
 	// App-specific version of this DB file format.
 	Version uint16
@@ -42,14 +66,26 @@ type Pdb struct {
 	ModTime time.Time
 	// Time of last backup
 	BackupTime time.Time
+	// Epoch the header times are stored relative to. Reading a file
+	// sets this to the epoch the file used, and it controls how the
+	// times are written out.
+	TimeEpoch Epoch
 	// Current modification number
 	ModNum uint32
-	// Unique ID for this database
+	// Unique ID for this database. Also the seed used to hand out
+	// unique IDs for new records.
 	UniqueIdSeed uint32
-	// Number of records in the database.
+	// TrackChanges makes the record editing methods bump ModNum and
+	// set ModTime to the current time whenever they change the
+	// database.
+	TrackChanges bool
+	// Number of records or resources in the database.
 	recordCount int
 	// List of records in the database.
 	Records []*Record
+	// List of resources in the database. Only resource databases
+	// have resources, and they don't have records.
+	Resources []*Resource
 	// Offset to the start of the appinfo block
 	appInfoOffset uint32
 	// Offset to the end of the appinfo block
@@ -66,6 +102,49 @@ type Pdb struct {
 	SortInfo []byte
 	// Total size of the file.
 	totalSize int64
+	// Record lists read from the file, in chain order.
+	lists []recordList
+	// Layout of the file as read, if it's being preserved.
+	layout *layout
+	// Set while a damaged file is being recovered, along with the
+	// problems found so far.
+	recovering bool
+	problems   ErrorList
+}
+
+// recordList notes where a record list was found in the file and how
+// many entries it held.
+type recordList struct {
+	offset uint32
+	count  int
+}
+
+// ReadOptions controls how a PDB file is read.
+type ReadOptions struct {
+	// PreserveLayout keeps track of the layout of the file as it was
+	// read: the order of the AppInfo, SortInfo and record blocks,
+	// any padding or gaps between them, and the exact header bytes.
+	// WriteFH then reproduces the file byte for byte if nothing's
+	// been changed. If records are resized the layout is kept, with
+	// offsets adjusted to match. Adding or removing records, or
+	// adding or removing the AppInfo or SortInfo blocks, discards
+	// the layout and the file is written out normally.
+	PreserveLayout bool
+	// Recover salvages what it can from a damaged file rather than
+	// failing at the first problem. Record list chains are followed
+	// as far as they can be, records and info blocks with bad
+	// offsets are clamped to the end of the file (leaving them
+	// empty), and a usable *Pdb is returned along with an ErrorList
+	// of the problems found. Records that couldn't be recovered have
+	// no data, and must be removed or filled in before the database
+	// can be written.
+	Recover bool
+}
+
+// IsResourceDB returns true if the attributes mark this as a resource
+// database, whose entries are resources rather than records.
+func (p *Pdb) IsResourceDB() bool {
+	return p.DBAttributes().Has(AttrResDB)
 }
 
 // The on-disk header format
@@ -93,18 +172,21 @@ func (p *Pdb) Validate() error {
 		return fmt.Errorf("Filetype must be exactly 4 characters")
 	}
 
-	if err := checkTime(p.CreateTime, false); err != nil {
+	if err := checkTime(p.CreateTime, false, p.TimeEpoch); err != nil {
 		return fmt.Errorf("Create time is invalid: %v", err)
 	}
-	if err := checkTime(p.ModTime, false); err != nil {
+	if err := checkTime(p.ModTime, false, p.TimeEpoch); err != nil {
 		return fmt.Errorf("Modification time is invalid: %v", err)
 	}
-	if err := checkTime(p.BackupTime, true); err != nil {
+	if err := checkTime(p.BackupTime, true, p.TimeEpoch); err != nil {
 		return fmt.Errorf("Backup time is invalid: %v", err)
 	}
 	if len(p.Name) > 32 {
 		return fmt.Errorf("Name too long")
 	}
+	if err := p.DBAttributes().validate(); err != nil {
+		return err
+	}
 	ui := make(map[uint32]int)
 	for i, r := range p.Records {
 		if r.UniqueID > 0xffffff {
@@ -116,12 +198,31 @@ func (p *Pdb) Validate() error {
 		ui[r.UniqueID] = i
 	}
 
+	if p.IsResourceDB() && len(p.Records) > 0 {
+		return fmt.Errorf("Resource database has %v records", len(p.Records))
+	}
+	if !p.IsResourceDB() && len(p.Resources) > 0 {
+		return fmt.Errorf("Record database has %v resources", len(p.Resources))
+	}
+	rt := make(map[string]int)
+	for i, r := range p.Resources {
+		if len(r.Type) != 4 {
+			return fmt.Errorf("Resource %v type must be exactly 4 characters", i)
+		}
+		k := fmt.Sprintf("%v/%v", r.Type, r.ID)
+		if or, ok := rt[k]; ok {
+			return fmt.Errorf("Resource %v and %v are both %v", i, or, k)
+		}
+		rt[k] = i
+	}
+
 	return nil
 }
 
-// checkTime makes sure the passed-in time is valid for PDB files. If
-// zeroOK is set then the time may be 0, otherwise not.
-func checkTime(t time.Time, zeroOK bool) error {
+// checkTime makes sure the passed-in time is valid for PDB files
+// using the passed-in epoch. If zeroOK is set then the time may be 0,
+// otherwise not.
+func checkTime(t time.Time, zeroOK bool, epoch Epoch) error {
 	if t.IsZero() {
 		if zeroOK {
 			return nil
@@ -129,8 +230,15 @@ func checkTime(t time.Time, zeroOK bool) error {
 		return fmt.Errorf("Time value must not be zero")
 	}
 
-	lowTime := time.Unix(oldDateSecs, 0)
+	// Unix-relative times must leave the high bit clear, and
+	// 1904-relative ones must have it set, or we can't tell them
+	// apart when reading them back. A raw time of 0 means unset.
+	lowTime := time.Unix(1, 0)
 	highTime := time.Unix(1<<31-1, 0)
+	if epoch == PalmEpoch {
+		lowTime = time.Unix(1<<31+oldDateSecs, 0)
+		highTime = time.Unix(1<<32-1+oldDateSecs, 0)
+	}
 
 	if lowTime.After(t) || highTime.Before(t) {
 		return fmt.Errorf("Time value out of range")
@@ -150,14 +258,58 @@ func Read(name string) (*Pdb, error) {
 
 // ReadFH reads a PDB file open on seekable io handle.
 func ReadFH(fh io.ReadSeeker) (*Pdb, error) {
-	p := &Pdb{}
+	return ReadFHOptions(fh, ReadOptions{})
+}
+
+// ReadFHOptions reads a PDB file open on a seekable io handle, using
+// the passed-in options. When recovering a damaged file, any problems
+// found are returned as an ErrorList alongside the *Pdb.
+func ReadFHOptions(fh io.ReadSeeker, opts ReadOptions) (*Pdb, error) {
 	size, err := fh.Seek(0, io.SeekEnd)
 	if err != nil {
 		return nil, err
 	}
 	fh.Seek(0, io.SeekStart)
+	p, err := parse(fh, size, opts.Recover)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.readInfoData(fh); err != nil {
+		return nil, err
+	}
+	if err := p.readRecordData(fh); err != nil {
+		return nil, err
+	}
+
+	if opts.PreserveLayout {
+		if err := p.captureLayout(fh); err != nil {
+			if err := p.problem(err); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	p.recovering = false
+	if len(p.problems) > 0 {
+		problems := p.problems
+		p.problems = nil
+		return p, problems
+	}
+
+	return p, nil
+}
+
+// parse reads the header and record list of a PDB file that's size
+// bytes long, and works out where each record starts and ends. It
+// doesn't read any of the record data. If recovering is set then
+// problems with the file are worked around where possible and noted
+// in p.problems.
+func parse(fh io.ReadSeeker, size int64, recovering bool) (*Pdb, error) {
+	p := &Pdb{}
 	p.totalSize = size
-	err = p.readHeader(fh)
+	p.recovering = recovering
+	err := p.readHeader(fh)
 	if err != nil {
 		return nil, err
 	}
@@ -167,51 +319,86 @@ func ReadFH(fh io.ReadSeeker) (*Pdb, error) {
 		return nil, err
 	}
 
-	// Now that we've read the headers and all the record offsets we can
-	// figure out how big each record is.
-	if err := p.updateRecordSizes(); err != nil {
+	if err := p.checkOffsets(); err != nil {
 		return nil, err
 	}
 
-	if err := p.readRecordData(fh); err != nil {
+	// Now that we've read the headers and all the record offsets we can
+	// figure out how big each record is.
+	if err := p.updateRecordSizes(); err != nil {
 		return nil, err
 	}
 
 	return p, nil
 }
 
-// readRecordData actually reads the data for the records from the file.
+// readInfoData reads the AppInfo and SortInfo blocks, if there are
+// any, from the file.
+func (p *Pdb) readInfoData(fh io.ReadSeeker) error {
+	if p.appInfoOffset > 0 {
+		b, err := readExtent(fh, &extent{p.appInfoOffset, p.appInfoEnd})
+		if err != nil {
+			return err
+		}
+		p.AppInfo = b
+	}
+	if p.sortInfoOffset > 0 {
+		b, err := readExtent(fh, &extent{p.sortInfoOffset, p.sortInfoEnd})
+		if err != nil {
+			return err
+		}
+		p.SortInfo = b
+	}
+	return nil
+}
+
+// readRecordData actually reads the data for the records and
+// resources from the file.
 func (p *Pdb) readRecordData(fh io.ReadSeeker) error {
 	for _, r := range p.Records {
-		_, err := fh.Seek(int64(r.offset), io.SeekStart)
+		b, err := readExtent(fh, &r.extent)
 		if err != nil {
 			return err
 		}
-		l := int(r.end - r.offset + 1)
-		b := make([]byte, l)
-		n, err := io.ReadFull(fh, b)
+		r.Data = b
+	}
+	for _, r := range p.Resources {
+		b, err := readExtent(fh, &r.extent)
 		if err != nil {
 			return err
 		}
-		if n != l {
-			return fmt.Errorf("Wanted to read %v bytes but read %v", l, n)
-		}
 		r.Data = b
 	}
 	return nil
 }
 
+// readExtent reads the bytes in the file covered by e.
+func readExtent(fh io.ReadSeeker, e *extent) ([]byte, error) {
+	_, err := fh.Seek(int64(e.offset), io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	l := e.size()
+	b := make([]byte, l)
+	n, err := io.ReadFull(fh, b)
+	if err != nil {
+		return nil, err
+	}
+	if n != l {
+		return nil, fmt.Errorf("Wanted to read %v bytes but read %v", l, n)
+	}
+	return b, nil
+}
+
 // updateRecordSizes figures out how big each record actually is. PDB
 // records have a start but no length or end, so we have to kind of
 // figure this out. We do it by assuming there are no overlapping
 // records, sorting the starts and working from that list.
 func (p *Pdb) updateRecordSizes() error {
 	// There's no guarantee that the records are listed in order in the
-	// file, so we need to make a copy of the record slice so we can
-	// sort it.
-	r := make([]*Record, len(p.Records))
-	copy(r, p.Records)
-	sort.Slice(r, func(i, j int) bool { return r[i].offset < r[j].offset })
+	// file, so we need to gather them up into a slice we can sort.
+	r := p.extents()
+	sort.SliceStable(r, func(i, j int) bool { return r[i].offset < r[j].offset })
 
 	// If we have either an appinfo or sortinfo block then tenatively
 	// set their ends to EOF. This is probably wrong, but we'll fix it
@@ -259,30 +446,80 @@ func (p *Pdb) updateRecordSizes() error {
 	return nil
 }
 
+// extents returns the locations of all the records and resources in
+// the database, in record list order.
+func (p *Pdb) extents() []*extent {
+	e := make([]*extent, 0, len(p.Records)+len(p.Resources))
+	for _, r := range p.Records {
+		e = append(e, &r.extent)
+	}
+	for _, r := range p.Resources {
+		e = append(e, &r.extent)
+	}
+	return e
+}
+
 // readRecordMetadata parses the record list in the pdb file, filling
 // out all the record structs with the offset, unique IDs, and
-// attributes. It does *not* grab the actual record data; that's
-// gotten later.
+// attributes. Resource databases get resource structs with the
+// offset, type, and ID instead. It does *not* grab the actual record
+// data; that's gotten later.
 func (p *Pdb) readRecordMetadata(start uint32, fh io.ReadSeeker) error {
 	var recs int
 
+	// Make sure we haven't been here before, or we'll go around in
+	// circles forever.
+	// When recovering, any problem with the chain stops us following
+	// it any further.
+	for _, l := range p.lists {
+		if l.offset == start {
+			return p.problem(formatError(-1, int64(start), ErrRecordListLoop))
+		}
+	}
+	if int64(start)+6 > p.totalSize {
+		return p.problem(formatError(-1, int64(start), ErrTruncated))
+	}
+
 	_, err := fh.Seek(int64(start), io.SeekStart)
 	if err != nil {
 		return err
 	}
 
 	header := make([]byte, 6)
-	_, err = io.ReadFull(fh, header)
+	if _, err := io.ReadFull(fh, header); err != nil {
+		return err
+	}
 
 	nextOffset := binary.BigEndian.Uint32(header[0:])
 	recs = int(binary.BigEndian.Uint16(header[4:]))
 
+	// Is the file long enough to hold all the entries in the list?
+	first := p.entryCount()
+	listEnd := int64(start) + 6 + int64(recs*p.entrySize())
+	if listEnd > p.totalSize {
+		fit := (p.totalSize - int64(start) - 6) / int64(p.entrySize())
+		if err := p.problem(formatError(first+int(fit), int64(start)+6+fit*int64(p.entrySize()), ErrTruncated)); err != nil {
+			return err
+		}
+		// Keep the entries that are there and stop.
+		recs = int(fit)
+		nextOffset = 0
+	}
+
 	for i := 0; i < recs; i++ {
-		ri := make([]byte, 8)
+		ri := make([]byte, p.entrySize())
 		_, err := io.ReadFull(fh, ri)
 		if err != nil {
 			return err
 		}
+		if p.IsResourceDB() {
+			res := &Resource{}
+			res.Type = string(ri[0:4])
+			res.ID = binary.BigEndian.Uint16(ri[4:])
+			res.offset = binary.BigEndian.Uint32(ri[6:])
+			p.Resources = append(p.Resources, res)
+			continue
+		}
 		rec := &Record{}
 		rec.offset = binary.BigEndian.Uint32(ri)
 		rec.Attribs = int8(ri[4])
@@ -292,15 +529,91 @@ func (p *Pdb) readRecordMetadata(start uint32, fh io.ReadSeeker) error {
 	}
 
 	p.recordCount += recs
+	p.lists = append(p.lists, recordList{offset: start, count: recs})
 
 	// Do we have another batch of records? If so, go read them.
 	if nextOffset > 0 {
+		if nextOffset < 0x48 {
+			return p.problem(formatError(-1, int64(nextOffset), ErrOverlappingRecords))
+		}
 		return p.readRecordMetadata(nextOffset, fh)
 	}
 
 	return nil
 }
 
+// inMetadata returns true if offset falls inside the header or one of
+// the record lists.
+func (p *Pdb) inMetadata(offset uint32) bool {
+	if offset < 0x48 {
+		return true
+	}
+	for _, l := range p.lists {
+		if offset >= l.offset && int64(offset) < int64(l.offset)+6+int64(l.count*p.entrySize()) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOffsets makes sure the records and info blocks all start
+// somewhere sensible: after the header and record lists, before the
+// end of the file, and not on top of an info block. Records can share
+// a start, since that's how empty records are written. When
+// recovering, bad info blocks are dropped and bad records are clamped
+// to the end of the file.
+func (p *Pdb) checkOffsets() error {
+	infoStarts := make(map[uint32]bool)
+	for _, off := range []*uint32{&p.appInfoOffset, &p.sortInfoOffset} {
+		if *off == 0 {
+			continue
+		}
+		var err error
+		switch {
+		case int64(*off) >= p.totalSize:
+			err = formatError(-1, int64(*off), ErrTruncated)
+		case p.inMetadata(*off) || infoStarts[*off]:
+			err = formatError(-1, int64(*off), ErrOverlappingRecords)
+		}
+		if err != nil {
+			if err := p.problem(err); err != nil {
+				return err
+			}
+			*off = 0
+			continue
+		}
+		infoStarts[*off] = true
+	}
+
+	for i, e := range p.extents() {
+		var err error
+		switch {
+		case int64(e.offset) >= p.totalSize:
+			err = formatError(i, int64(e.offset), ErrTruncated)
+		case p.inMetadata(e.offset) || infoStarts[e.offset]:
+			err = formatError(i, int64(e.offset), ErrOverlappingRecords)
+		}
+		if err != nil {
+			if err := p.problem(err); err != nil {
+				return err
+			}
+			e.offset = uint32(p.totalSize)
+		}
+	}
+	return nil
+}
+
+// problem notes err as a problem with the file. If we're recovering
+// the problem is saved and nil returned so the caller can work around
+// it and carry on, otherwise err is returned as is.
+func (p *Pdb) problem(err error) error {
+	if !p.recovering {
+		return err
+	}
+	p.problems = append(p.problems, err)
+	return nil
+}
+
 // Write serializes the PDB to the named file. If the file exists it will be overwritten.
 func (p *Pdb) Write(name string) error {
 	if err := p.Validate(); err != nil {
@@ -328,71 +641,62 @@ func (p *Pdb) WriteFH(fh io.Writer) error {
 	if err := p.Validate(); err != nil {
 		return err
 	}
-	if err := p.fixUpMetadata(); err != nil {
-		return err
-	}
 
-	if err := p.writeHeader(fh); err != nil {
-		return err
-	}
-	if err := p.writeRecordMetadata(fh); err != nil {
-		return err
-	}
-	if err := p.writeMetadata(fh); err != nil {
-		return err
+	// Use the layout the file was read with if we're preserving it
+	// and it still fits.
+	blocks := p.defaultLayout()
+	if p.layout != nil && p.layout.fits(p) {
+		blocks = p.layout.blocks
 	}
-	if err := p.writeRecords(fh); err != nil {
+	data := p.entryData()
+	if err := p.fixUpMetadata(blocks, dataSizes(data)); err != nil {
 		return err
 	}
 
-	return nil
+	return p.writeBlocks(fh, blocks, data)
 }
 
-// fixUpMetadata goes through the records and sort/info blobs,
+// fixUpMetadata goes through the blocks of the file layout,
 // calculating the offset information so we can write it out later. We
 // have to do this because the record headers come before the record
-// bodies, but need to have the offset data in them.
-func (p *Pdb) fixUpMetadata() error {
-	// How many records do we have? If it's more than 64K then we need
-	// to chunk them, which is annoying. For now we won't allow that.
-	if len(p.Records) > 0xffff {
-		return fmt.Errorf("%v records exceeds the maximum of %v", len(p.Records), 0xffff)
-	}
-
-	// Header is 0x48 bytes
-	totalSize := uint32(0x48)
-	// Add 6 bytes for the record list header
-	totalSize += 6
-	// Two more if there aren't any records
-	if len(p.Records) == 0 {
-		totalSize += 2
-	}
-	// Add 8 bytes per record.
-	totalSize += uint32(len(p.Records) * 8)
-
-	// Start the running total. We always write out the sort area, then the appinfo area.
-	if len(p.SortInfo) > 0 {
-		p.sortInfoOffset = totalSize
-		// Add in the sortinfo area
-		totalSize += uint32(len(p.SortInfo))
-	} else {
-		p.sortInfoOffset = 0
-	}
-
-	if len(p.AppInfo) > 0 {
-		p.appInfoOffset = totalSize
-		// Add in the appinfo
-		totalSize += uint32(len(p.AppInfo))
-	} else {
-		p.appInfoOffset = 0
-	}
-
-	// Add in the sizes of all the records
-	for i, r := range p.Records {
-		r.offset = totalSize
-		totalSize += uint32(len(r.Data))
-		if len(r.Data) == 0 {
-			return fmt.Errorf("Record %v has a size of 0!", i)
+// bodies, but need to have the offset data in them. sizes holds the
+// size of each record or resource.
+func (p *Pdb) fixUpMetadata(blocks []block, sizes []int) error {
+	entries := p.extents()
+
+	var lists []*block
+	p.appInfoOffset = 0
+	p.sortInfoOffset = 0
+	totalSize := int64(0)
+	for i := range blocks {
+		b := &blocks[i]
+		b.offset = uint32(totalSize)
+		switch b.kind {
+		case headerBlock:
+			// Header is 0x48 bytes
+			totalSize += 0x48
+		case recordListBlock:
+			// 6 bytes for the record list header, then 8 bytes per
+			// record or 10 per resource.
+			totalSize += int64(6 + b.count*p.entrySize())
+			lists = append(lists, b)
+		case appInfoBlock:
+			p.appInfoOffset = b.offset
+			totalSize += int64(len(p.AppInfo))
+		case sortInfoBlock:
+			p.sortInfoOffset = b.offset
+			totalSize += int64(len(p.SortInfo))
+		case entryBlock:
+			if sizes[b.index] == 0 {
+				if p.IsResourceDB() {
+					return fmt.Errorf("Resource %v has a size of 0!", b.index)
+				}
+				return fmt.Errorf("Record %v has a size of 0!", b.index)
+			}
+			entries[b.index].offset = b.offset
+			totalSize += int64(sizes[b.index])
+		case gapBlock:
+			totalSize += int64(len(b.gap))
 		}
 	}
 
@@ -402,6 +706,54 @@ func (p *Pdb) fixUpMetadata() error {
 		return fmt.Errorf("Total filesize of %v exceeds max of 2G", totalSize)
 	}
 
+	// Each record list points to the next one in the chain, which is
+	// the one holding the following entries.
+	sort.Slice(lists, func(i, j int) bool { return lists[i].index < lists[j].index })
+	for i, l := range lists {
+		l.next = 0
+		if i < len(lists)-1 {
+			l.next = lists[i+1].offset
+		}
+	}
+
+	return nil
+}
+
+// writeBlocks writes out the blocks of the file layout, in order,
+// taking the record or resource data from data. fixUpMetadata must
+// have been called on them first.
+func (p *Pdb) writeBlocks(fh io.Writer, blocks []block, data [][]byte) error {
+	for _, b := range blocks {
+		switch b.kind {
+		case headerBlock:
+			if err := p.writeHeader(fh); err != nil {
+				return err
+			}
+		case recordListBlock:
+			if err := p.writeRecordList(fh, b.index, b.index+b.count, b.next); err != nil {
+				return err
+			}
+		case appInfoBlock:
+			if _, err := fh.Write(p.AppInfo); err != nil {
+				return fmt.Errorf("Error writing appinfo data: %v", err)
+			}
+		case sortInfoBlock:
+			if _, err := fh.Write(p.SortInfo); err != nil {
+				return fmt.Errorf("Error writing sortinfo data: %v", err)
+			}
+		case entryBlock:
+			if _, err := fh.Write(data[b.index]); err != nil {
+				if p.IsResourceDB() {
+					return fmt.Errorf("Error writing resource %v: %v", b.index, err)
+				}
+				return fmt.Errorf("Error writing record %v: %v", b.index, err)
+			}
+		case gapBlock:
+			if _, err := fh.Write(b.gap); err != nil {
+				return fmt.Errorf("Error writing padding: %v", err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -413,10 +765,9 @@ func (p *Pdb) writeHeader(fh io.Writer) error {
 	h.Attr = p.Attributes
 	h.Version = p.Version
 
-	// We always write out unix-relative times
-	h.Create = uint32(p.CreateTime.Unix())
-	h.Modified = uint32(p.ModTime.Unix())
-	h.Backup = uint32(p.BackupTime.Unix())
+	h.Create = writeTime(p.CreateTime, p.TimeEpoch)
+	h.Modified = writeTime(p.ModTime, p.TimeEpoch)
+	h.Backup = writeTime(p.BackupTime, p.TimeEpoch)
 
 	copy(h.Filetype[:], p.Filetype[0:4])
 	copy(h.Creator[:], p.Creator[0:4])
@@ -425,75 +776,103 @@ func (p *Pdb) writeHeader(fh io.Writer) error {
 	h.SortInfoOffset = p.sortInfoOffset
 	h.UniqueIdSeed = p.UniqueIdSeed
 
+	if p.layout != nil {
+		p.layout.restoreHeader(p, &h)
+	}
+
 	return binary.Write(fh, binary.BigEndian, &h)
 }
 
-func (p *Pdb) writeRecordMetadata(fh io.Writer) error {
-	if _, err := fh.Write([]byte{0, 0, 0, 0}); err != nil {
-		return fmt.Errorf("Error writing nil nextRecordListID offset: %v", err)
+// writeRecordList writes out a single record list holding entries
+// first through last-1, chained to the list at offset next. A next of
+// 0 marks the final list.
+func (p *Pdb) writeRecordList(fh io.Writer, first, last int, next uint32) error {
+	if err := binary.Write(fh, binary.BigEndian, next); err != nil {
+		return fmt.Errorf("Error writing nextRecordListID offset: %v", err)
 	}
-	var nr uint16
-	nr = uint16(len(p.Records))
-	if err := binary.Write(fh, binary.BigEndian, nr); err != nil {
+	if err := binary.Write(fh, binary.BigEndian, uint16(last-first)); err != nil {
 		return fmt.Errorf("Error writing record count: %v", err)
 	}
-	if nr == 0 {
-		if _, err := fh.Write([]byte{0, 0}); err != nil {
-			return fmt.Errorf("Error writing record list pad: %v", err)
-		}
-	}
 
-	for i, r := range p.Records {
+	if p.IsResourceDB() {
+		return p.writeResourceEntries(fh, first, last)
+	}
+	for i, r := range p.Records[first:last] {
 		if err := binary.Write(fh, binary.BigEndian, uint32(r.offset)); err != nil {
-			return fmt.Errorf("Error writing offset for record %v: %v", i, err)
+			return fmt.Errorf("Error writing offset for record %v: %v", first+i, err)
 		}
 		var attr uint32
 		attr = uint32(r.Attribs)<<24 + (r.UniqueID & 0xffffff)
 		if err := binary.Write(fh, binary.BigEndian, attr); err != nil {
-			return fmt.Errorf("Error writing attributes for record %v: %v", i, err)
+			return fmt.Errorf("Error writing attributes for record %v: %v", first+i, err)
 		}
 	}
-
 	return nil
 }
 
-// writeMetadata writes out the sortinfo and appinfo data, if they're set
-func (p *Pdb) writeMetadata(fh io.Writer) error {
-	if len(p.AppInfo) > 0 {
-		if _, err := fh.Write(p.AppInfo); err != nil {
-			return fmt.Errorf("Error writing appinfo data: %v", err)
+// writeResourceEntries writes out the record list entries for
+// resources first through last-1.
+func (p *Pdb) writeResourceEntries(fh io.Writer, first, last int) error {
+	for i, r := range p.Resources[first:last] {
+		var t [4]byte
+		copy(t[:], r.Type)
+		if _, err := fh.Write(t[:]); err != nil {
+			return fmt.Errorf("Error writing type for resource %v: %v", first+i, err)
 		}
-	}
-	if len(p.SortInfo) > 0 {
-		if _, err := fh.Write(p.SortInfo); err != nil {
-			return fmt.Errorf("Error writing sortinfo data: %v", err)
+		if err := binary.Write(fh, binary.BigEndian, r.ID); err != nil {
+			return fmt.Errorf("Error writing ID for resource %v: %v", first+i, err)
+		}
+		if err := binary.Write(fh, binary.BigEndian, r.offset); err != nil {
+			return fmt.Errorf("Error writing offset for resource %v: %v", first+i, err)
 		}
 	}
 	return nil
 }
 
-// writeRecords writes out the record data.
-func (p *Pdb) writeRecords(fh io.Writer) error {
-	for i, r := range p.Records {
-		if _, err := fh.Write(r.Data); err != nil {
-			return fmt.Errorf("Error writing record %v: %v", i, err)
-		}
+// entryCount returns the number of entries in the record list, which
+// is the number of records or resources depending on the type of
+// database.
+func (p *Pdb) entryCount() int {
+	if p.IsResourceDB() {
+		return len(p.Resources)
 	}
-	return nil
+	return len(p.Records)
+}
+
+// entrySize returns the size of a single record list entry. Records
+// take 8 bytes and resources take 10.
+func (p *Pdb) entrySize() int {
+	if p.IsResourceDB() {
+		return 10
+	}
+	return 8
+}
+
+// maxListRecords is the most records a single record list can hold,
+// since the count is stored in 16 bits.
+const maxListRecords = 0xffff
+
+// recordListCount returns the number of chained record lists needed
+// to hold n records. There's always at least one list, even if it's
+// empty.
+func recordListCount(n int) int {
+	if n == 0 {
+		return 1
+	}
+	return (n + maxListRecords - 1) / maxListRecords
 }
 
 func (p *Pdb) readHeader(fh io.ReadSeeker) error {
 	h := header{}
+	if p.totalSize < 0x48 {
+		return formatError(-1, p.totalSize, ErrTruncated)
+	}
 	err := binary.Read(fh, binary.BigEndian, &h)
 	if err != nil {
 		return err
 	}
 
-	n := string(h.Name[:])
-	if i := strings.Index(n, "\x00"); i != -1 {
-		n = n[0:i]
-	}
-	p.Name = n
+	p.Name = headerName(h.Name)
 
 	p.Attributes = h.Attr
 	p.Version = h.Version
@@ -502,6 +881,7 @@ func (p *Pdb) readHeader(fh io.ReadSeeker) error {
 	p.CreateTime = readTime(h.Create)
 	p.ModTime = readTime(h.Modified)
 	p.BackupTime = readTime(h.Backup)
+	p.TimeEpoch = readEpoch(h.Create, h.Modified, h.Backup)
 
 	p.ModNum = h.ModNum
 	p.appInfoOffset = h.AppInfoOffset
@@ -513,9 +893,69 @@ func (p *Pdb) readHeader(fh io.ReadSeeker) error {
 	return nil
 }
 
+// headerName returns the database name from the raw name field of
+// the header, which is NUL terminated.
+func headerName(raw [32]byte) string {
+	n := string(raw[:])
+	if i := strings.Index(n, "\x00"); i != -1 {
+		n = n[0:i]
+	}
+	return n
+}
+
 // Base date for MOBI. Jan 1, 1904.
 const oldDateSecs = -2082844800
 
+// Epoch says which base date the times in a PDB header count from.
+type Epoch int
+
+const (
+	// UnixEpoch times count seconds from Jan 1, 1970. They can
+	// represent times up to 2038.
+	UnixEpoch Epoch = iota
+	// PalmEpoch times count seconds from Jan 1, 1904, like PalmOS
+	// and classic Mac OS do. Only times from 1972 to 2040 can be
+	// written, since earlier ones would be mistaken for Unix times.
+	PalmEpoch
+)
+
+func (e Epoch) String() string {
+	switch e {
+	case UnixEpoch:
+		return "Unix"
+	case PalmEpoch:
+		return "Palm"
+	}
+	return fmt.Sprintf("Epoch(%d)", int(e))
+}
+
+// readEpoch works out which epoch a header's times use from the
+// first of the raw times that's set.
+func readEpoch(rawTimes ...uint32) Epoch {
+	for _, t := range rawTimes {
+		if t == 0 {
+			continue
+		}
+		if t&0x80000000 != 0 {
+			return PalmEpoch
+		}
+		return UnixEpoch
+	}
+	return UnixEpoch
+}
+
+// writeTime returns the raw form of t relative to the passed-in
+// epoch. An unset time is written as 0.
+func writeTime(t time.Time, epoch Epoch) uint32 {
+	if t.IsZero() {
+		return 0
+	}
+	if epoch == PalmEpoch {
+		return uint32(t.Unix() - oldDateSecs)
+	}
+	return uint32(t.Unix())
+}
+
 // readTime takes the first four bytes of the passed in byte slice and
 // interprets them as a mobi datestamp.
 func readTime(rawTime uint32) time.Time {
@@ -1,6 +1,9 @@
 package pdb
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -110,3 +113,283 @@ func checkPDB(t *testing.T, p *Pdb) {
 	}
 
 }
+
+func TestWriteChainedRecordLists(t *testing.T) {
+	// Enough records to need three chained record lists.
+	count := maxListRecords*2 + 5
+	p := &Pdb{
+		Name:     "chained",
+		Filetype: "TEST",
+		Creator:  "TEST",
+	}
+	for i := 0; i < count; i++ {
+		p.Records = append(p.Records, &Record{UniqueID: uint32(i), Data: []byte{byte(i)}})
+	}
+
+	var b bytes.Buffer
+	if err := p.WriteFH(&b); err != nil {
+		t.Fatalf("Can't write chained pdb: %v", err)
+	}
+
+	raw := b.Bytes()
+	wantNext := uint32(0x48 + 6 + maxListRecords*8)
+	if got := binary.BigEndian.Uint32(raw[0x48:]); got != wantNext {
+		t.Errorf("nextRecordListID: got %v, want %v", got, wantNext)
+	}
+	if got := binary.BigEndian.Uint16(raw[0x4c:]); got != maxListRecords {
+		t.Errorf("first list count: got %v, want %v", got, maxListRecords)
+	}
+
+	np, err := ReadFH(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Can't re-read chained pdb: %v", err)
+	}
+	if np.recordCount != count {
+		t.Errorf("recordCount: got %v, want %v", np.recordCount, count)
+	}
+	if len(np.Records) != count {
+		t.Fatalf("Records: got %v, want %v", len(np.Records), count)
+	}
+	for i, r := range np.Records {
+		if r.UniqueID != uint32(i) {
+			t.Errorf("Record[%v].UniqueID: got %v, want %v", i, r.UniqueID, i)
+			break
+		}
+		if !bytes.Equal(r.Data, []byte{byte(i)}) {
+			t.Errorf("Record[%v].Data: got %v, want %v", i, r.Data, []byte{byte(i)})
+			break
+		}
+	}
+}
+
+func TestResourceRoundTrip(t *testing.T) {
+	p := &Pdb{
+		Name:       "resources",
+		Attributes: uint16(AttrResDB),
+		Filetype:   "appl",
+		Creator:    "TEST",
+		Resources: []*Resource{
+			{Type: "code", ID: 0, Data: []byte{0, 0, 0, 1}},
+			{Type: "code", ID: 1, Data: []byte("some code")},
+			{Type: "tAIN", ID: 1000, Data: []byte("App\x00")},
+		},
+	}
+
+	var b bytes.Buffer
+	if err := p.WriteFH(&b); err != nil {
+		t.Fatalf("Can't write resource pdb: %v", err)
+	}
+	raw := b.Bytes()
+
+	// The first entry in the record list is 10 bytes: type, ID, offset.
+	if got := string(raw[0x4e:0x52]); got != "code" {
+		t.Errorf("Resource[0] type on disk: got %q, want %q", got, "code")
+	}
+	wantOffset := uint32(0x48 + 6 + 3*10)
+	if got := binary.BigEndian.Uint32(raw[0x54:]); got != wantOffset {
+		t.Errorf("Resource[0] offset on disk: got %v, want %v", got, wantOffset)
+	}
+
+	np, err := ReadFH(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Can't re-read resource pdb: %v", err)
+	}
+	if !np.IsResourceDB() {
+		t.Errorf("IsResourceDB: got false, want true")
+	}
+	if len(np.Records) != 0 {
+		t.Errorf("Records: got %v, want 0", len(np.Records))
+	}
+	if len(np.Resources) != len(p.Resources) {
+		t.Fatalf("Resources: got %v, want %v", len(np.Resources), len(p.Resources))
+	}
+	for i, want := range p.Resources {
+		got := np.Resources[i]
+		if got.Type != want.Type || got.ID != want.ID || !bytes.Equal(got.Data, want.Data) {
+			t.Errorf("Resource[%v]: got %v/%v/%q, want %v/%v/%q", i, got.Type, got.ID, got.Data, want.Type, want.ID, want.Data)
+		}
+	}
+
+	f, err := Open(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatalf("Can't open resource pdb: %v", err)
+	}
+	for i, want := range p.Resources {
+		got, err := f.ResourceData(i)
+		if err != nil {
+			t.Errorf("ResourceData(%v): %v", i, err)
+			continue
+		}
+		if !bytes.Equal(got, want.Data) {
+			t.Errorf("ResourceData(%v): got %q, want %q", i, got, want.Data)
+		}
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	tests := []struct {
+		name string
+		p    *Pdb
+	}{
+		{
+			name: "records in resource db",
+			p: &Pdb{
+				Attributes: uint16(AttrResDB),
+				Records:    []*Record{{Data: []byte{1}}},
+			},
+		},
+		{
+			name: "resources in record db",
+			p: &Pdb{
+				Resources: []*Resource{{Type: "code", Data: []byte{1}}},
+			},
+		},
+		{
+			name: "short type",
+			p: &Pdb{
+				Attributes: uint16(AttrResDB),
+				Resources:  []*Resource{{Type: "cod", Data: []byte{1}}},
+			},
+		},
+		{
+			name: "duplicate resource",
+			p: &Pdb{
+				Attributes: uint16(AttrResDB),
+				Resources: []*Resource{
+					{Type: "code", ID: 1, Data: []byte{1}},
+					{Type: "code", ID: 1, Data: []byte{2}},
+				},
+			},
+		},
+	}
+
+	now := time.Now()
+	for _, test := range tests {
+		test.p.Filetype = "TEST"
+		test.p.Creator = "TEST"
+		test.p.CreateTime = now
+		test.p.ModTime = now
+		if err := test.p.Validate(); err == nil {
+			t.Errorf("Validate(%v): got nil error, want one", test.name)
+		}
+	}
+}
+
+func TestWriteInfoBlocks(t *testing.T) {
+	appInfo := []byte("application info block")
+	sortInfo := []byte("sort info")
+	records := []*Record{
+		{UniqueID: 1, Data: []byte("first record")},
+		{UniqueID: 2, Data: []byte("second record")},
+	}
+
+	for combo := 0; combo < 8; combo++ {
+		p := &Pdb{
+			Name:     "info",
+			Filetype: "TEST",
+			Creator:  "TEST",
+		}
+		if combo&1 != 0 {
+			p.AppInfo = appInfo
+		}
+		if combo&2 != 0 {
+			p.SortInfo = sortInfo
+		}
+		if combo&4 != 0 {
+			p.Records = records
+		}
+		name := fmt.Sprintf("appinfo %v, sortinfo %v, records %v", combo&1 != 0, combo&2 != 0, combo&4 != 0)
+
+		var b bytes.Buffer
+		if err := p.WriteFH(&b); err != nil {
+			t.Errorf("%v: can't write: %v", name, err)
+			continue
+		}
+		np, err := ReadFH(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Errorf("%v: can't re-read: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(np.AppInfo, p.AppInfo) {
+			t.Errorf("%v: AppInfo got %q, want %q", name, np.AppInfo, p.AppInfo)
+		}
+		if !bytes.Equal(np.SortInfo, p.SortInfo) {
+			t.Errorf("%v: SortInfo got %q, want %q", name, np.SortInfo, p.SortInfo)
+		}
+		if len(np.Records) != len(p.Records) {
+			t.Errorf("%v: got %v records, want %v", name, len(np.Records), len(p.Records))
+			continue
+		}
+		for i, r := range p.Records {
+			if !bytes.Equal(np.Records[i].Data, r.Data) {
+				t.Errorf("%v: Record[%v].Data got %q, want %q", name, i, np.Records[i].Data, r.Data)
+			}
+		}
+	}
+}
+
+func TestTimeEpoch(t *testing.T) {
+	p, err := Read(sampleFile)
+	if err != nil {
+		t.Fatalf("Unable to open %q: %v", sampleFile, err)
+	}
+	if p.TimeEpoch != UnixEpoch {
+		t.Errorf("TimeEpoch: got %v, want %v", p.TimeEpoch, UnixEpoch)
+	}
+
+	create := time.Unix(1500000000, 0)
+	backup := time.Unix(1600000000, 0)
+	p.CreateTime = create
+	p.ModTime = create
+	p.BackupTime = backup
+	p.TimeEpoch = PalmEpoch
+
+	var b bytes.Buffer
+	if err := p.WriteFH(&b); err != nil {
+		t.Fatalf("Can't write: %v", err)
+	}
+	raw := b.Bytes()
+	wantRaw := uint32(1500000000 - oldDateSecs)
+	if got := binary.BigEndian.Uint32(raw[0x24:]); got != wantRaw {
+		t.Errorf("Raw create time: got %#x, want %#x", got, wantRaw)
+	}
+
+	np, err := ReadFH(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Can't re-read: %v", err)
+	}
+	if np.TimeEpoch != PalmEpoch {
+		t.Errorf("TimeEpoch: got %v, want %v", np.TimeEpoch, PalmEpoch)
+	}
+	if !np.CreateTime.Equal(create) || !np.ModTime.Equal(create) || !np.BackupTime.Equal(backup) {
+		t.Errorf("Times: got %v/%v/%v, want %v/%v/%v", np.CreateTime, np.ModTime, np.BackupTime, create, create, backup)
+	}
+}
+
+func TestCheckTime(t *testing.T) {
+	tests := []struct {
+		when  string
+		epoch Epoch
+		ok    bool
+	}{
+		{"2018-11-01", UnixEpoch, true},
+		{"2018-11-01", PalmEpoch, true},
+		{"1969-12-31", UnixEpoch, false},
+		{"1971-06-01", UnixEpoch, true},
+		{"1971-06-01", PalmEpoch, false},
+		{"2039-01-01", UnixEpoch, false},
+		{"2039-01-01", PalmEpoch, true},
+		{"2041-01-01", PalmEpoch, false},
+	}
+
+	for _, test := range tests {
+		when, err := time.Parse("2006-01-02", test.when)
+		if err != nil {
+			t.Fatalf("Bad time: %v", err)
+		}
+		err = checkTime(when, false, test.epoch)
+		if (err == nil) != test.ok {
+			t.Errorf("checkTime(%v, %v): got %v, want ok %v", test.when, test.epoch, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package pdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxUniqueID is the largest unique ID a record can have, since
+// they're only 24 bits.
+const maxUniqueID = 0xffffff
+
+// NextUniqueID returns a unique ID that no record in the database is
+// using, and advances UniqueIdSeed past it. IDs are handed out in
+// order from the seed, wrapping around at the 24 bit limit. ID 0 is
+// never handed out.
+func (p *Pdb) NextUniqueID() (uint32, error) {
+	used := make(map[uint32]bool, len(p.Records))
+	for _, r := range p.Records {
+		used[r.UniqueID] = true
+	}
+
+	// Record IDs can be sparse or repeated, so the only way to know
+	// they're all in use is to try every one.
+	id := p.UniqueIdSeed & maxUniqueID
+	for tries := 0; tries < maxUniqueID; tries++ {
+		id++
+		if id > maxUniqueID {
+			id = 1
+		}
+		if !used[id] {
+			p.UniqueIdSeed = id
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("All %v unique IDs are in use", maxUniqueID)
+}
+
+// AppendRecord adds a new record holding data to the end of the
+// database, giving it the next unique ID.
+func (p *Pdb) AppendRecord(data []byte) (*Record, error) {
+	return p.InsertRecord(len(p.Records), data)
+}
+
+// InsertRecord adds a new record holding data to the database so it
+// becomes record i, giving it the next unique ID. The records from i
+// on move up one.
+func (p *Pdb) InsertRecord(i int, data []byte) (*Record, error) {
+	if p.IsResourceDB() {
+		return nil, fmt.Errorf("Can't add records to a resource database")
+	}
+	if i < 0 || i > len(p.Records) {
+		return nil, fmt.Errorf("Record %v out of range; database has %v records", i, len(p.Records))
+	}
+	id, err := p.NextUniqueID()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Record{UniqueID: id, Data: data}
+	p.Records = append(p.Records, nil)
+	copy(p.Records[i+1:], p.Records[i:])
+	p.Records[i] = r
+	p.touch()
+	return r, nil
+}
+
+// DeleteRecord removes record i from the database. The records after
+// it move down one.
+func (p *Pdb) DeleteRecord(i int) error {
+	if i < 0 || i >= len(p.Records) {
+		return fmt.Errorf("Record %v out of range; database has %v records", i, len(p.Records))
+	}
+	copy(p.Records[i:], p.Records[i+1:])
+	p.Records[len(p.Records)-1] = nil
+	p.Records = p.Records[:len(p.Records)-1]
+	p.touch()
+	return nil
+}
+
+// MoveRecord moves record from so that it becomes record to, shifting
+// the records in between to make room.
+func (p *Pdb) MoveRecord(from, to int) error {
+	if from < 0 || from >= len(p.Records) {
+		return fmt.Errorf("Record %v out of range; database has %v records", from, len(p.Records))
+	}
+	if to < 0 || to >= len(p.Records) {
+		return fmt.Errorf("Record %v out of range; database has %v records", to, len(p.Records))
+	}
+	if from == to {
+		return nil
+	}
+	r := p.Records[from]
+	if from < to {
+		copy(p.Records[from:], p.Records[from+1:to+1])
+	} else {
+		copy(p.Records[to+1:], p.Records[to:from])
+	}
+	p.Records[to] = r
+	p.touch()
+	return nil
+}
+
+// RecordByID returns the record with the passed-in unique ID and its
+// index, or nil and -1 if there's no such record.
+func (p *Pdb) RecordByID(id uint32) (*Record, int) {
+	for i, r := range p.Records {
+		if r.UniqueID == id {
+			return r, i
+		}
+	}
+	return nil, -1
+}
+
+// touch notes that the database has been changed, if we're tracking
+// changes.
+func (p *Pdb) touch() {
+	if !p.TrackChanges {
+		return
+	}
+	p.ModNum++
+	p.ModTime = time.Now()
+}
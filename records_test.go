@@ -0,0 +1,150 @@
+package pdb
+
+import (
+	"testing"
+)
+
+// recordIDs returns the unique IDs of the records in p, in order.
+func recordIDs(p *Pdb) []uint32 {
+	var ids []uint32
+	for _, r := range p.Records {
+		ids = append(ids, r.UniqueID)
+	}
+	return ids
+}
+
+func equalIDs(got, want []uint32) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNextUniqueID(t *testing.T) {
+	p := &Pdb{
+		UniqueIdSeed: 3,
+		Records:      []*Record{{UniqueID: 4}, {UniqueID: 5}, {UniqueID: 7}},
+	}
+	want := []uint32{6, 8, 9}
+	for _, w := range want {
+		got, err := p.NextUniqueID()
+		if err != nil {
+			t.Fatalf("NextUniqueID: %v", err)
+		}
+		if got != w {
+			t.Errorf("NextUniqueID: got %v, want %v", got, w)
+		}
+		p.Records = append(p.Records, &Record{UniqueID: got})
+	}
+	if p.UniqueIdSeed != 9 {
+		t.Errorf("UniqueIdSeed: got %v, want 9", p.UniqueIdSeed)
+	}
+
+	// IDs wrap around at 24 bits, skipping 0.
+	p = &Pdb{UniqueIdSeed: maxUniqueID, Records: []*Record{{UniqueID: 1}}}
+	if got, err := p.NextUniqueID(); err != nil || got != 2 {
+		t.Errorf("NextUniqueID after wrapping: got %v/%v, want 2", got, err)
+	}
+
+	// Having as many records as there are IDs doesn't mean they're
+	// all used, since records can share IDs or have ID 0.
+	if testing.Short() {
+		return
+	}
+	r := &Record{}
+	p = &Pdb{Records: make([]*Record, maxUniqueID)}
+	for i := range p.Records {
+		p.Records[i] = r
+	}
+	if got, err := p.NextUniqueID(); err != nil || got != 1 {
+		t.Errorf("NextUniqueID with %v records using ID 0: got %v/%v, want 1", maxUniqueID, got, err)
+	}
+}
+
+func TestEditRecords(t *testing.T) {
+	p := &Pdb{Name: "edit", Filetype: "TEST", Creator: "TEST"}
+	for _, d := range []string{"a", "b", "c"} {
+		if _, err := p.AppendRecord([]byte(d)); err != nil {
+			t.Fatalf("AppendRecord(%q): %v", d, err)
+		}
+	}
+	if got, want := recordIDs(p), []uint32{1, 2, 3}; !equalIDs(got, want) {
+		t.Errorf("After appending: got IDs %v, want %v", got, want)
+	}
+
+	r, err := p.InsertRecord(1, []byte("x"))
+	if err != nil {
+		t.Fatalf("InsertRecord: %v", err)
+	}
+	if got, want := recordIDs(p), []uint32{1, 4, 2, 3}; !equalIDs(got, want) {
+		t.Errorf("After inserting: got IDs %v, want %v", got, want)
+	}
+	if got, i := p.RecordByID(4); got != r || i != 1 {
+		t.Errorf("RecordByID(4): got %v/%v, want %v/1", got, i, r)
+	}
+
+	if err := p.MoveRecord(1, 3); err != nil {
+		t.Fatalf("MoveRecord(1, 3): %v", err)
+	}
+	if got, want := recordIDs(p), []uint32{1, 2, 3, 4}; !equalIDs(got, want) {
+		t.Errorf("After moving up: got IDs %v, want %v", got, want)
+	}
+	if err := p.MoveRecord(2, 0); err != nil {
+		t.Fatalf("MoveRecord(2, 0): %v", err)
+	}
+	if got, want := recordIDs(p), []uint32{3, 1, 2, 4}; !equalIDs(got, want) {
+		t.Errorf("After moving down: got IDs %v, want %v", got, want)
+	}
+
+	if err := p.DeleteRecord(0); err != nil {
+		t.Fatalf("DeleteRecord(0): %v", err)
+	}
+	if got, want := recordIDs(p), []uint32{1, 2, 4}; !equalIDs(got, want) {
+		t.Errorf("After deleting: got IDs %v, want %v", got, want)
+	}
+	if got, i := p.RecordByID(3); got != nil || i != -1 {
+		t.Errorf("RecordByID(3) after deleting: got %v/%v, want nil/-1", got, i)
+	}
+
+	for _, i := range []int{-1, 3} {
+		if err := p.DeleteRecord(i); err == nil {
+			t.Errorf("DeleteRecord(%v): got nil error, want one", i)
+		}
+	}
+	if _, err := p.InsertRecord(4, []byte("y")); err == nil {
+		t.Errorf("InsertRecord(4): got nil error, want one")
+	}
+}
+
+func TestTrackChanges(t *testing.T) {
+	p := &Pdb{ModNum: 5}
+	if _, err := p.AppendRecord([]byte("a")); err != nil {
+		t.Fatalf("AppendRecord: %v", err)
+	}
+	if p.ModNum != 5 || !p.ModTime.IsZero() {
+		t.Errorf("Untracked change: got ModNum %v ModTime %v, want 5 and zero", p.ModNum, p.ModTime)
+	}
+
+	p.TrackChanges = true
+	if _, err := p.AppendRecord([]byte("b")); err != nil {
+		t.Fatalf("AppendRecord: %v", err)
+	}
+	if err := p.DeleteRecord(0); err != nil {
+		t.Fatalf("DeleteRecord: %v", err)
+	}
+	if p.ModNum != 7 || p.ModTime.IsZero() {
+		t.Errorf("Tracked changes: got ModNum %v ModTime %v, want 7 and set", p.ModNum, p.ModTime)
+	}
+}
+
+func TestInsertRecordResourceDB(t *testing.T) {
+	p := &Pdb{Attributes: uint16(AttrResDB)}
+	if _, err := p.AppendRecord([]byte("a")); err == nil {
+		t.Errorf("AppendRecord on a resource database: got nil error, want one")
+	}
+}
@@ -0,0 +1,182 @@
+package pdb
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Writer writes a PDB file a record at a time, so the record data
+// doesn't all have to be in memory at once. The header, record list,
+// and AppInfo and SortInfo blocks are written first, taken from a
+// *Pdb whose records (or resources) hold everything but the data.
+// The record data is then passed to WriteRecord in order.
+//
+// The record list comes before the record data in the file, so a
+// Writer either needs to be told how big each record will be up
+// front, or needs to be able to seek back and fill in the offsets
+// once all the records have been written.
+type Writer struct {
+	w io.Writer
+	// Set if the record offsets get filled in at Close.
+	ws io.WriteSeeker
+	// Where the file starts in ws.
+	start int64
+
+	// Our copy of the database, with no record data.
+	p *Pdb
+	// The blocks of the file layout, and how many of them come
+	// before the record data.
+	blocks []block
+	prefix int
+	// Size of each record. When seeking these get filled in as the
+	// records are written.
+	sizes []int
+	// Number of records written so far.
+	n      int
+	closed bool
+}
+
+// NewWriter returns a Writer that writes the database described by
+// hdr to w. The data of hdr's records is ignored; sizes holds the
+// size of each record instead, and the data passed to WriteRecord
+// must match.
+func NewWriter(w io.Writer, hdr *Pdb, sizes []int) (*Writer, error) {
+	wr, err := newWriter(hdr)
+	if err != nil {
+		return nil, err
+	}
+	if len(sizes) != wr.p.entryCount() {
+		return nil, fmt.Errorf("Got %v sizes for %v records", len(sizes), wr.p.entryCount())
+	}
+	wr.w = w
+	wr.sizes = append([]int(nil), sizes...)
+	if err := wr.p.fixUpMetadata(wr.blocks, wr.sizes); err != nil {
+		return nil, err
+	}
+	if err := wr.p.writeBlocks(w, wr.blocks[:wr.prefix], nil); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// NewSeekWriter returns a Writer that writes the database described
+// by hdr to w. The data of hdr's records is ignored, and records of
+// any size may be passed to WriteRecord. Close goes back and fills in
+// the record offsets.
+func NewSeekWriter(w io.WriteSeeker, hdr *Pdb) (*Writer, error) {
+	wr, err := newWriter(hdr)
+	if err != nil {
+		return nil, err
+	}
+	wr.w = w
+	wr.ws = w
+	wr.start, err = w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing before the record data depends on the record sizes
+	// except the offsets, so write it out with placeholder sizes
+	// for now.
+	placeholder := make([]int, wr.p.entryCount())
+	for i := range placeholder {
+		placeholder[i] = 1
+	}
+	if err := wr.p.fixUpMetadata(wr.blocks, placeholder); err != nil {
+		return nil, err
+	}
+	if err := wr.p.writeBlocks(w, wr.blocks[:wr.prefix], nil); err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// newWriter sets up the parts of a Writer common to both kinds.
+func newWriter(hdr *Pdb) (*Writer, error) {
+	// Take a copy so we don't disturb the caller's records.
+	p := *hdr
+	p.layout = nil
+	p.Records = nil
+	p.Resources = nil
+	for _, r := range hdr.Records {
+		p.Records = append(p.Records, &Record{Attribs: r.Attribs, UniqueID: r.UniqueID})
+	}
+	for _, r := range hdr.Resources {
+		p.Resources = append(p.Resources, &Resource{Type: r.Type, ID: r.ID})
+	}
+
+	// Make sure we have valid times in the header.
+	t := time.Now()
+	if p.CreateTime.IsZero() {
+		p.CreateTime = t
+	}
+	if p.ModTime.IsZero() {
+		p.ModTime = t
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	wr := &Writer{p: &p, blocks: p.defaultLayout()}
+	for wr.prefix < len(wr.blocks) && wr.blocks[wr.prefix].kind != entryBlock {
+		wr.prefix++
+	}
+	return wr, nil
+}
+
+// WriteRecord writes the data for the next record.
+func (w *Writer) WriteRecord(data []byte) error {
+	if w.closed {
+		return fmt.Errorf("Writer is closed")
+	}
+	if w.n >= w.p.entryCount() {
+		return fmt.Errorf("All %v records have already been written", w.p.entryCount())
+	}
+	if w.ws == nil && len(data) != w.sizes[w.n] {
+		return fmt.Errorf("Record %v is %v bytes, but should be %v", w.n, len(data), w.sizes[w.n])
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("Record %v has a size of 0!", w.n)
+	}
+	if _, err := w.w.Write(data); err != nil {
+		return fmt.Errorf("Error writing record %v: %v", w.n, err)
+	}
+	if w.ws != nil {
+		w.sizes = append(w.sizes, len(data))
+	}
+	w.n++
+	return nil
+}
+
+// Close finishes writing the file, checking that all the records have
+// been written. If the Writer can seek it goes back and fills in the
+// record offsets. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.n != w.p.entryCount() {
+		return fmt.Errorf("Only %v of %v records were written", w.n, w.p.entryCount())
+	}
+	if w.ws == nil {
+		return nil
+	}
+
+	if err := w.p.fixUpMetadata(w.blocks, w.sizes); err != nil {
+		return err
+	}
+	end, err := w.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := w.ws.Seek(w.start, io.SeekStart); err != nil {
+		return err
+	}
+	if err := w.p.writeBlocks(w.ws, w.blocks[:w.prefix], nil); err != nil {
+		return err
+	}
+	_, err = w.ws.Seek(end, io.SeekStart)
+	return err
+}
@@ -0,0 +1,120 @@
+package pdb
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// streamTestPdb returns a database to write with a Writer.
+func streamTestPdb() *Pdb {
+	t := time.Unix(1500000000, 0)
+	p := &Pdb{
+		Name:       "stream",
+		Filetype:   "TEST",
+		Creator:    "TEST",
+		CreateTime: t,
+		ModTime:    t,
+		AppInfo:    []byte("appinfo"),
+	}
+	for i, d := range []string{"first", "second record", "third"} {
+		p.Records = append(p.Records, &Record{UniqueID: uint32(i + 1), Attribs: int8(RecAttrDirty), Data: []byte(d)})
+	}
+	return p
+}
+
+// writeStream writes out the records of p with w, then closes it.
+func writeStream(t *testing.T, w *Writer, p *Pdb) {
+	for i, r := range p.Records {
+		if err := w.WriteRecord(r.Data); err != nil {
+			t.Fatalf("WriteRecord(%v): %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	p := streamTestPdb()
+	var want bytes.Buffer
+	if err := p.WriteFH(&want); err != nil {
+		t.Fatalf("WriteFH: %v", err)
+	}
+
+	var sizes []int
+	for _, r := range p.Records {
+		sizes = append(sizes, len(r.Data))
+	}
+	var got bytes.Buffer
+	w, err := NewWriter(&got, p, sizes)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	writeStream(t, w, p)
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("Writer output differs from WriteFH at offset %v", firstDiff(got.Bytes(), want.Bytes()))
+	}
+}
+
+func TestSeekWriter(t *testing.T) {
+	p := streamTestPdb()
+	var want bytes.Buffer
+	if err := p.WriteFH(&want); err != nil {
+		t.Fatalf("WriteFH: %v", err)
+	}
+
+	wf, err := ioutil.TempFile("", "stream.pdb")
+	if err != nil {
+		t.Fatalf("can't open temp file for testing: %v", err)
+	}
+	defer os.Remove(wf.Name())
+	defer wf.Close()
+
+	// Leave some junk at the start of the file to make sure the
+	// offsets are patched relative to where the Writer started.
+	wf.Write([]byte("junk"))
+	w, err := NewSeekWriter(wf, p)
+	if err != nil {
+		t.Fatalf("NewSeekWriter: %v", err)
+	}
+	writeStream(t, w, p)
+
+	wf.Seek(4, io.SeekStart)
+	got, err := ioutil.ReadAll(wf)
+	if err != nil {
+		t.Fatalf("Can't read back temp file: %v", err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("Writer output differs from WriteFH at offset %v", firstDiff(got, want.Bytes()))
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+	p := streamTestPdb()
+	var b bytes.Buffer
+
+	if _, err := NewWriter(&b, p, []int{1, 2}); err == nil {
+		t.Errorf("NewWriter with too few sizes: got nil error, want one")
+	}
+
+	w, err := NewWriter(&b, p, []int{5, 13, 5})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if err := w.WriteRecord([]byte("wrong size")); err == nil {
+		t.Errorf("WriteRecord with the wrong size: got nil error, want one")
+	}
+	if err := w.WriteRecord([]byte("first")); err != nil {
+		t.Errorf("WriteRecord: %v", err)
+	}
+	if err := w.Close(); err == nil {
+		t.Errorf("Close with records missing: got nil error, want one")
+	}
+	if err := w.WriteRecord([]byte("second record")); err == nil {
+		t.Errorf("WriteRecord after Close: got nil error, want one")
+	}
+}